@@ -0,0 +1,86 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+)
+
+// The transaction an input file is imported in. The statements of the import are prepared once
+// and bound to the current transaction, which is committed every batchSize rows and at the end
+// of the file. A fatal error ends cm without a commit, so SQLite rolls the transaction back:
+// the file is imported completely or, in batches, up to the last batch committed.
+type importTx struct {
+	db         *DB
+	fileName   string
+	tx         *sql.Tx
+	batchSize  int
+	rows       int
+	prepared   []*sql.Stmt
+	statements []*CMD
+}
+
+// Begin the import of a file in batches of batchSize rows, 0 for the whole file in one transaction
+func beginImport(db *DB, fileName string, batchSize int) *importTx {
+	t := &importTx{db: db, fileName: fileName, batchSize: batchSize}
+	t.begin()
+	return t
+}
+
+// Begin a transaction and bind the prepared statements to it
+func (t *importTx) begin() {
+	tx, err := t.db.Begin()
+	if err != nil {
+		log.Fatalf("Cannot begin the import of %s: %s", t.fileName, err)
+	}
+	t.tx = tx
+	for i, statement := range t.statements {
+		statement.Stmt = tx.Stmt(t.prepared[i])
+	}
+}
+
+// Prepare an SQL statement of the import
+func (t *importTx) prepare(name string, statement string) *CMD {
+	command := prepareSQL(name, statement, t.db)
+	t.prepared = append(t.prepared, command.Stmt)
+	command.Stmt = t.tx.Stmt(command.Stmt)
+	t.statements = append(t.statements, command)
+	return command
+}
+
+// Execute an SQL statement in the transaction of the import
+func (t *importTx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return t.tx.Exec(query, args...)
+}
+
+// Query a row in the transaction of the import
+func (t *importTx) QueryRow(query string, args ...interface{}) *sql.Row {
+	return t.tx.QueryRow(query, args...)
+}
+
+// Count a row of the file, a full batch is committed before the next row
+func (t *importTx) next() {
+	if t.batchSize > 0 && t.rows == t.batchSize {
+		t.commit()
+		t.begin()
+		t.rows = 0
+	}
+	t.rows++
+}
+
+// Commit the rows imported since the last commit
+func (t *importTx) commit() {
+	if err := t.tx.Commit(); err != nil {
+		if t.batchSize > 0 {
+			log.Fatalf("Cannot commit the import of %s, the rows since the last batch were rolled back: %s", t.fileName, err)
+		}
+		log.Fatalf("Cannot commit the import of %s, it was rolled back: %s", t.fileName, err)
+	}
+}
+
+// Commit the end of the file and close the statements of the import
+func (t *importTx) finish() {
+	t.commit()
+	for _, statement := range t.prepared {
+		statement.Close()
+	}
+}
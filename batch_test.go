@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// A value of a synthetic input row: ids with the prefixes checked on import, valid dates,
+// and the field name with the row number for everything else
+func benchValue(field string, i int) string {
+	switch field {
+	case "id":
+		return fmt.Sprintf("EV%08d", i)
+	case "created_at", "mandates_created_at":
+		return "2026-01-01T08:00:00Z"
+	case "payments_charge_date":
+		return "2026-01-01"
+	case "payments_id":
+		return fmt.Sprintf("PM%08d", i)
+	case "mandates_id", "elevate_mandate_reference":
+		return fmt.Sprintf("MD%08d", i)
+	case "customers_id", "crm_gocardless_id":
+		return fmt.Sprintf("CU%08d", i)
+	case "crm_id":
+		return fmt.Sprintf("C%08d", i)
+	case "elevate_account_number", "crm_account_number", "customers_metadata_leadID":
+		return fmt.Sprintf("A%08d", i)
+	case "crm_stage_name":
+		return "ACTIVE"
+	case "action":
+		return "cancelled"
+	}
+	return fmt.Sprintf("%s %d", field, i)
+}
+
+// Write a synthetic input file of a source with rows rows, its header are the field names
+func writeBenchFile(fileName string, source string, rows int) {
+	var header []string
+	for _, spec := range columnAliases[source] {
+		header = append(header, spec.field)
+	}
+	file := createCSVFile(fileName, header, exportOptions{delimiter: ','})
+	record := make([]string, len(header))
+	for i := 1; i <= rows; i++ {
+		for j, field := range header {
+			record[j] = benchValue(field, i)
+		}
+		file.write(record)
+	}
+	file.close()
+}
+
+// Import a synthetic file of each source into a new database with a commit per row, as cm did
+// before the imports had transactions, in batches (-batch 1000) and in one transaction:
+//
+//	go test -run '^$' -bench Import
+func BenchmarkImport(b *testing.B) {
+	const rows = 2000 // per file, ns/op is the duration of the import of one file
+	stdout := os.Stdout
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer devNull.Close()
+
+	for _, source := range []string{"crm", "elevate", "mandates", "payments"} {
+		fileName := filepath.Join(b.TempDir(), "bench-"+source+".csv")
+		writeBenchFile(fileName, source, rows)
+		for _, batchSize := range []int{1, 1000, 0} {
+			name := fmt.Sprintf("%s/batch=%d", source, batchSize)
+			b.Run(name, func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					b.StopTimer()
+					os.Stdout = devNull
+					db := newTestDatabase(b)
+					b.StartTimer()
+					switch source {
+					case "crm":
+						importCRMAccounts(db, fileName, "2026-01-01", true, inputFormat{}, batchSize)
+					case "elevate":
+						importElevateAccounts(db, fileName, "2026-01-01", inputFormat{}, batchSize)
+					case "mandates":
+						importMandateEvents(db, fileName, "2026-01-01", inputFormat{}, batchSize)
+					case "payments":
+						importPaymentEvents(db, fileName, "2026-01-01", inputFormat{}, batchSize)
+					}
+					b.StopTimer()
+					os.Stdout = stdout
+					var inserted int
+					if err := db.QueryRow(`SELECT rows_inserted FROM importRuns ORDER BY run_id DESC LIMIT 1`).Scan(&inserted); err != nil {
+						b.Fatal(err)
+					}
+					if inserted != rows {
+						b.Fatalf("%d rows inserted, want %d", inserted, rows)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Status of a case as set by the teams with cm feedback
+var caseStatuses = []string{"open", "contacted", "resolved", "no-action", "escalated"}
+
+// Statuses of a case that is still worked on: it gets further events of its
+// mandate and is carried forward to the next day's export
+const activeCaseStatuses = `'open', 'contacted', 'escalated'`
+
+// A case groups all failed or cancelled events of one mandate of one customer across days
+type mandateCase struct {
+	case_id   int64
+	opened_at string
+	follow_up bool
+	carried   bool
+	status    string
+	owner     string
+	notes     string
+	events    []*mandateEvent
+}
+
+// Find the case of a mandate event. An event which was assigned before keeps its case,
+// else it joins the active case of its mandate and customer, or else opens a new case.
+// It is a follow-up, if its case was opened on an earlier day.
+func assignCase(db *DB, e *mandateEvent) mandateCase {
+	var c mandateCase
+
+	SQLGetAssignedCase := `
+		SELECT cases.case_id, opened_at, follow_up, status, owner, notes
+		FROM caseEvents
+		INNER JOIN cases USING (case_id)
+		WHERE event_id = ?`
+	err := db.QueryRow(SQLGetAssignedCase, e.id).Scan(&c.case_id, &c.opened_at, &c.follow_up, &c.status, &c.owner, &c.notes)
+	if err == nil {
+		return c
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		log.Fatalf("Cannot read caseEvents for event %s: %s", e.id, err)
+	}
+
+	SQLGetActiveCase := `
+		SELECT case_id, opened_at, status, owner, notes
+		FROM cases
+		WHERE mandates_id = ? AND customers_id = ? AND status IN (` + activeCaseStatuses + `)
+		ORDER BY case_id DESC
+		LIMIT 1`
+	err = db.QueryRow(SQLGetActiveCase, e.mandates_id, e.customers_id).Scan(&c.case_id, &c.opened_at, &c.status, &c.owner, &c.notes)
+	if errors.Is(err, sql.ErrNoRows) {
+		result, err := db.Exec(`INSERT INTO cases(mandates_id, customers_id, opened_at, status) values(?, ?, ?, 'open')`,
+			e.mandates_id, e.customers_id, e.imported_at)
+		if err != nil {
+			log.Fatalf("Insert into table cases failed for event %s: %s", e.id, err)
+		}
+		c.case_id, _ = result.LastInsertId()
+		c.opened_at = e.imported_at
+		c.status = "open"
+	} else if err != nil {
+		log.Fatalf("Cannot read cases for event %s: %s", e.id, err)
+	}
+	c.follow_up = c.opened_at < e.imported_at
+
+	_, err = db.Exec(`INSERT INTO caseEvents(event_id, case_id, follow_up) values(?, ?, ?)`, e.id, c.case_id, c.follow_up)
+	if err != nil {
+		log.Fatalf("Insert into table caseEvents failed for event %s: %s", e.id, err)
+	}
+	return c
+}
+
+// Group events into their cases, in the order the cases first appear
+func groupCases(db *DB, events []mandateEvent) []*mandateCase {
+	var cases []*mandateCase
+	byId := make(map[int64]*mandateCase)
+	for i := range events {
+		e := &events[i]
+		c := assignCase(db, e)
+		if existing, ok := byId[c.case_id]; ok {
+			existing.events = append(existing.events, e)
+			continue
+		}
+		c.events = []*mandateEvent{e}
+		byId[c.case_id] = &c
+		cases = append(cases, &c)
+	}
+	return cases
+}
+
+// Active cases opened before day timestamp without an event on that day, with all their events,
+// to be carried forward to the day's export
+func carriedForwardCases(db *DB, timestamp string, today []*mandateCase) []*mandateCase {
+	exclude := make(map[int64]bool)
+	for _, c := range today {
+		exclude[c.case_id] = true
+	}
+
+	SQLGetActiveCases := `
+		SELECT case_id, opened_at, status, owner, notes
+		FROM cases
+		WHERE status IN (` + activeCaseStatuses + `) AND opened_at < ?
+		ORDER BY case_id`
+	row, err := db.Query(SQLGetActiveCases, timestamp)
+	if err != nil {
+		log.Fatalf("Cannot read active cases: %s", err)
+	}
+	var cases []*mandateCase
+	for row.Next() {
+		c := &mandateCase{carried: true}
+		row.Scan(&c.case_id, &c.opened_at, &c.status, &c.owner, &c.notes)
+		if !exclude[c.case_id] {
+			cases = append(cases, c)
+		}
+	}
+	row.Close()
+
+	SQLGetCaseEvents := `
+		SELECT ` + mandateEventColumns + `
+		FROM caseEvents
+		INNER JOIN mandateEvents ON mandateEvents.id = caseEvents.event_id
+		WHERE case_id = ? AND imported_at <= ?
+		ORDER BY created_at, id`
+	var withEvents []*mandateCase
+	for _, c := range cases {
+		row, err := db.Query(SQLGetCaseEvents, c.case_id, timestamp)
+		if err != nil {
+			log.Fatalf("Cannot read events of case %d: %s", c.case_id, err)
+		}
+		for row.Next() {
+			e, err := scanMandateEvent(row)
+			if err != nil {
+				log.Fatalf("Cannot read events of case %d: %s", c.case_id, err)
+			}
+			c.events = append(c.events, &e)
+		}
+		row.Close()
+		if len(c.events) > 0 {
+			withEvents = append(withEvents, c)
+		}
+	}
+	return withEvents
+}
+
+// "new" for a case opened with the day's events, "follow-up" for events on a case opened earlier,
+// "carried forward" for an active case without events on the day
+func (c *mandateCase) caseType() string {
+	if c.carried {
+		return "carried forward"
+	}
+	if c.follow_up {
+		return "follow-up"
+	}
+	return "new"
+}
+
+// Days since the case was opened, on day timestamp
+func (c *mandateCase) ageInDays(timestamp string) int {
+	opened, err1 := time.Parse("2006-01-02", c.opened_at)
+	day, err2 := time.Parse("2006-01-02", timestamp)
+	if err1 != nil || err2 != nil {
+		return 0
+	}
+	return int(day.Sub(opened).Hours() / 24)
+}
+
+// The latest event of a case, which is exported for the case
+func (c *mandateCase) latestEvent() *mandateEvent {
+	return c.events[len(c.events)-1]
+}
+
+// All events of a case across days, e.g. "2022-08-01 failed (insufficient_funds); 2022-08-03 cancelled (bank_account_closed)"
+func caseEventHistory(db *DB, caseId int64) (string, int) {
+	SQLGetHistory := `
+		SELECT created_at, action, details_cause
+		FROM caseEvents
+		INNER JOIN mandateEvents ON mandateEvents.id = caseEvents.event_id
+		WHERE case_id = ?
+		ORDER BY created_at, id`
+	row, err := db.Query(SQLGetHistory, caseId)
+	if err != nil {
+		log.Fatalf("Cannot read history of case %d: %s", caseId, err)
+	}
+	defer row.Close()
+
+	var history []string
+	for row.Next() {
+		var created_at, action, details_cause string
+		row.Scan(&created_at, &action, &details_cause)
+		if len(created_at) > 10 {
+			created_at = created_at[:10]
+		}
+		entry := created_at + " " + action
+		if details_cause != "" {
+			entry += " (" + details_cause + ")"
+		}
+		history = append(history, entry)
+	}
+	return strings.Join(history, "; "), len(history)
+}
+
+// Check a status from the teams' feedback, accepting e.g. "No Action" for "no-action"
+func normalizeCaseStatus(status string) (string, error) {
+	normalized := strings.ReplaceAll(strings.ToLower(strings.TrimSpace(status)), " ", "-")
+	for _, s := range caseStatuses {
+		if s == normalized {
+			return s, nil
+		}
+	}
+	return "", fmt.Errorf("unknown case status %q, expected one of %s", status, strings.Join(caseStatuses, ", "))
+}
+
+// Import the feedback of a team: its annotated export file, matched on the event id
+// (or case_id), updates the status, owner and notes of the cases. A row which cannot
+// be read, has no case or an unknown status is reported and counted as failed.
+// Returns the number of rows updated and failed.
+func importCaseFeedback(db *DB, csvFileName string) (int, int) {
+	fileData, err := os.Open(csvFileName)
+	if err != nil {
+		log.Fatalf("Cannot open feedback file: %s %s", csvFileName, err)
+	}
+	defer fileData.Close()
+
+	recordData := readCSVFile(fileData, csvFileName, inputFormat{})
+	columns := readHeader("feedback", csvFileName, recordData)
+	changedAt := time.Now().Format("2006-01-02 15:04:05")
+	var updated int
+	var failedLines []string
+
+	for {
+		record, err := recordData.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		line := 0
+		var parseError *csv.ParseError
+		if errors.As(err, &parseError) {
+			line = parseError.StartLine
+		} else if len(record) > 0 {
+			line, _ = recordData.FieldPos(0)
+		}
+		if err != nil {
+			fmt.Println("ERROR:   Cannot read record from", csvFileName, err)
+			failedLines = append(failedLines, strconv.Itoa(line))
+			continue
+		}
+
+		id := columns.get(record, "id")
+		var caseId int64
+		if id != "" {
+			err = db.QueryRow(`SELECT case_id FROM caseEvents WHERE event_id = ?`, id).Scan(&caseId)
+		} else {
+			caseId, err = strconv.ParseInt(columns.get(record, "case_id"), 10, 64)
+		}
+		if err != nil {
+			fmt.Println("ERROR:   No case found for id =", id, columns.get(record, "case_id"), err)
+			failedLines = append(failedLines, strconv.Itoa(line))
+			continue
+		}
+
+		status := columns.get(record, "case_status")
+		if status != "" {
+			if status, err = normalizeCaseStatus(status); err != nil {
+				fmt.Println("ERROR:   Case", caseId, "of id =", id, err)
+				failedLines = append(failedLines, strconv.Itoa(line))
+				continue
+			}
+		}
+
+		SQLUpdateCase := `
+			UPDATE cases SET
+			    status=CASE WHEN ?1 != '' THEN ?1 ELSE status END,
+			    status_changed_at=CASE WHEN ?1 != '' AND ?1 != status THEN ?4 ELSE status_changed_at END,
+			    owner=CASE WHEN ?2 != '' THEN ?2 ELSE owner END,
+			    notes=CASE WHEN ?3 != '' THEN ?3 ELSE notes END
+			WHERE case_id = ?5`
+		result, err := db.Exec(SQLUpdateCase, status, columns.get(record, "case_owner"), columns.get(record, "case_notes"), changedAt, caseId)
+		var rows int64
+		if err == nil {
+			rows, err = result.RowsAffected()
+		}
+		switch {
+		case err != nil:
+			fmt.Println("ERROR:   Update of table cases failed for case", caseId, err)
+			failedLines = append(failedLines, strconv.Itoa(line))
+		case rows == 0:
+			fmt.Println("ERROR:   No case found for case_id =", caseId)
+			failedLines = append(failedLines, strconv.Itoa(line))
+		default:
+			fmt.Println("SUCCESS: Update of table cases for case", caseId, "status:", status)
+			updated++
+		}
+	}
+
+	fmt.Println("SUCCESS: Imported feedback of", csvFileName+":", updated, "rows updated,", len(failedLines), "failed")
+	if len(failedLines) > 0 {
+		fmt.Println("ERROR:  ", len(failedLines), "rows of", csvFileName, "were not imported, fix them and import the file again: lines", strings.Join(failedLines, ", "))
+	}
+	return updated, len(failedLines)
+}
+
+// cm feedback <file.csv>: update the cases from a team's annotated export file
+func feedbackCommand(args []string, defaultDatabaseName string) {
+	var dbName string
+	flags := flag.NewFlagSet("feedback", flag.ExitOnError)
+	flags.StringVar(&dbName, "db", defaultDatabaseName, "Sqlite database to use")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage:")
+		fmt.Fprintln(flags.Output(), "  cm feedback [-db file] <file.csv>   with columns id (or case_id), case_status, case_owner, case_notes")
+		fmt.Fprintln(flags.Output(), "  case_status is one of:", strings.Join(caseStatuses, ", "))
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	db := createDatabase(dbName)
+	defer db.Close()
+	createSchema(db)
+	importCaseFeedback(db, flags.Arg(0))
+}
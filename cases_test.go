@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNormalizeCaseStatus(t *testing.T) {
+	tests := []struct {
+		status, want string
+	}{
+		{"open", "open"},
+		{" Contacted ", "contacted"},
+		{"No Action", "no-action"},
+		{"NO-ACTION", "no-action"},
+		{"closed", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		got, err := normalizeCaseStatus(tt.status)
+		if got != tt.want || (err == nil) != (tt.want != "") {
+			t.Errorf("normalizeCaseStatus(%q) = %q, %v, want %q", tt.status, got, err, tt.want)
+		}
+	}
+}
+
+func TestImportCaseFeedback(t *testing.T) {
+	db := newTestDatabase(t)
+	for _, statement := range []string{
+		`INSERT INTO cases(case_id, mandates_id, customers_id, opened_at, status, owner, notes, status_changed_at) VALUES
+			(1, 'MD1', 'CU1', '2026-10-14', 'open', '', 'old note', ''),
+			(2, 'MD2', 'CU2', '2026-10-14', 'open', 'Anna', '', ''),
+			(3, 'MD3', 'CU3', '2026-10-14', 'contacted', '', '', '2026-10-15 08:00:00')`,
+		`INSERT INTO caseEvents(event_id, case_id, follow_up) VALUES ('EV1', 1, 0), ('EV2', 2, 0), ('EV3', 3, 0), ('EV4', 3, 1)`,
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+	content := "id,case_id,case_status,case_owner,case_notes,customers_name\n" +
+		"EV1,,Contacted,Anna,,Name 1\n" + // by event id, notes kept
+		",2,No Action,,done,Name 2\n" + // by case_id, owner kept
+		"EV9,,resolved,,,Typo\n" + // unknown event id
+		",99,resolved,,,Typo\n" + // unknown case_id
+		"EV3,,closed,,,Name 3\n" + // unknown status
+		"EV4,,,Ben,,Name 3\n" // follow-up event of case 3, status kept
+	fileName := filepath.Join(t.TempDir(), "mandates-to-check-2026-10-16.csv")
+	if err := os.WriteFile(fileName, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	updated, failed := importCaseFeedback(db, fileName)
+	if updated != 3 || failed != 3 {
+		t.Errorf("%d rows updated and %d failed, want 3 and 3", updated, failed)
+	}
+	want := map[int]string{
+		1: "contacted|Anna|old note|changed",
+		2: "no-action|Anna|done|changed",
+		3: "contacted|Ben||2026-10-15 08:00:00",
+	}
+	for caseId, w := range want {
+		var status, owner, notes, changed_at string
+		err := db.QueryRow(`SELECT status, owner, notes, status_changed_at FROM cases WHERE case_id = ?`, caseId).Scan(&status, &owner, &notes, &changed_at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if changed_at != "" && changed_at != "2026-10-15 08:00:00" {
+			changed_at = "changed"
+		}
+		if got := status + "|" + owner + "|" + notes + "|" + changed_at; got != w {
+			t.Errorf("case %d = %q, want %q", caseId, got, w)
+		}
+	}
+}
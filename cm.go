@@ -11,14 +11,12 @@ package main
 
 import (
 	"database/sql"
-	"encoding/csv"
 	"errors"
 	"flag"
 	"io"
 	"log"
 	"os"
 	"fmt"
-//	"strconv"
 	"strings"
 	"time"
 	"path/filepath"
@@ -27,6 +25,7 @@ import (
 
 type DB struct {
     *sql.DB
+    name string
 }
 
 type CMD struct {
@@ -55,7 +54,24 @@ func createDatabase(dbName string) (*DB) {
 	if err != nil {
 		log.Fatalf("Cannot connect to database: %s %s", dbName, err)
 	}
-	return &DB{db}
+	return &DB{db, dbName}
+}
+
+// Open an existing Sqlite3 database read-only, at whatever schema version it is
+func openExistingDatabase(dbName string) (*DB) {
+	if _, err := os.Stat(dbName); err != nil {
+		log.Fatalf("Cannot open database: %s", err)
+	}
+	db := createDatabase("file:" + dbName + "?mode=ro")
+	db.name = dbName
+	return db
+}
+
+// Open an existing Sqlite3 database read-only, which must be at the current schema version
+func openDatabaseReadOnly(dbName string) (*DB) {
+	db := openExistingDatabase(dbName)
+	checkSchemaVersion(db)
+	return db
 }
 
 // Prepare an SQL statement for the database
@@ -81,143 +97,84 @@ func prepareAndExecuteSQL(name string, statement string, db *DB) {
 	executeSQL(name, command)
 }
 
-// Create or Open Accounts table in Database
-func createTableElevateAccounts(db *DB) {
-	SQLCreateAccountsDB := `
-		CREATE TABLE IF NOT EXISTS elevateAccounts (
-			elevate_account_number    text primary key,
-			elevate_mandate_reference text,
-			elevate_customer_name     text 
-	)`
-	prepareAndExecuteSQL("create table elevateAccounts", SQLCreateAccountsDB, db)
-}
-
-// Create or Open CRM table in Database
-func createTableCRMAccounts(db *DB) {
-	SQLCreateCRMAccountsDB := `
-	  CREATE TABLE IF NOT EXISTS crmAccounts (
-		crm_id                text primary key,
-		crm_account_number    text,
-		crm_name              text,
-		crm_email             text,
-		crm_premise_address   text,
-		crm_stage_name        text,
-		crm_gocardless_id     text,
-		crm_zen_user_id       text
-	)`
-	prepareAndExecuteSQL("create table crmAccounts", SQLCreateCRMAccountsDB, db)
-}
-
-// Create or Open mandateEvents table in Database
-func createTableMandateEvents(db *DB) {
-	SQLMandateEvents := `
-	  CREATE TABLE IF NOT EXISTS mandateEvents (
-		id 										text primary key,	
-		created_at 								text,	
-		resource_type 							text,	
-		action 									text,	
-		details_origin 							text,	
-		details_cause 							text,	
-		details_description	        			text,	
-		details_scheme 							text,	
-		details_reason_code 					text,	
-		links_previous_customer_bank_account	text,	
-		links_new_customer_bank_account 		text,	
-		links_parent_event 						text,	
-		links_mandate 							text,	
-		mandates_id 							text,	
-		mandates_created_at 					text,	
-		mandates_reference 						text,	
-		mandates_status 						text,	
-		mandates_scheme 						text,	
-		mandates_next_possible_charge_date   	text,	
-		mandates_payments_require_approval   	text,	
-		mandates_links_customer_bank_account 	text,	
-		mandates_links_creditor 				text,	
-		customers_id							text,
-		customers_given_name 					text,	
-		customers_family_name 					text,	
-		customers_company_name 					text,	
-		customers_metadata_leadID 				text,	
-		customers_metadata_link 				text,	
-		customers_metadata_xero 				text,	
-		mandates_metadata_xero 					text,
-		imported_at                             text,
-		customers_name                          text
-	)`
-	prepareAndExecuteSQL("create table mandateEvents", SQLMandateEvents, db)
-}
-
-// Create Index idx_mandate_events_imported_at
-func createIndexMandateEventsTimestamp(db *DB) {
-	SQLCreateDBIndexOnMandateEventsTimestamp := `
-       CREATE INDEX IF NOT EXISTS idx_mandate_events_imported_at 
-	   ON mandateEvents(imported_at)
-	`
-	prepareAndExecuteSQL("create index idx_mandate_events_imported_at", SQLCreateDBIndexOnMandateEventsTimestamp, db)
-}
-
-// Create Index idx_crm_accounts_crm_account_number
-func createIndexCRMAccountsAccountNumber(db *DB) {
-	SQLCreateIndex := `
-       CREATE INDEX IF NOT EXISTS idx_crm_accounts_crm_account_number 
-	   ON crmAccounts(crm_account_number)
-	`
-	prepareAndExecuteSQL("create index idx_crm_accounts_crm_account_number", SQLCreateIndex, db)
-}
-
-// Create Index idx_crm_accounts_crm_name
-func createIndexCRMAccountsName(db *DB) {
-	SQLCreateIndex := `
-       CREATE INDEX IF NOT EXISTS idx_crm_accounts_crm_name
-	   ON crmAccounts(crm_name)
-	`
-	prepareAndExecuteSQL("create index idx_crm_accounts_crm_name", SQLCreateIndex, db)
-}
-
-// Create Index idx_crm_accounts_crm_gocardless_id
-func createIndexCRMAccountsGoCardlessId(db *DB) {
-	SQLCreateIndex := `
-       CREATE INDEX IF NOT EXISTS idx_crm_accounts_crm_gocardless_id
-	   ON crmAccounts(crm_gocardless_id)
-	`
-	prepareAndExecuteSQL("create index idx_crm_accounts_crm_gocardless_id", SQLCreateIndex, db)
-}
-
-// Create Index idx_elevate_accounts_elevate_mandate_reference
-func createIndexElevateAccountsMandateReference(db *DB) {
-	SQLCreateIndex := `
-       CREATE INDEX IF NOT EXISTS idx_elevate_accounts_elevate_mandate_reference
-	   ON elevateAccounts(elevate_mandate_reference)
-	`
-	prepareAndExecuteSQL("create index idx_elevate_accounts_elevate_mandate_reference", SQLCreateIndex, db)
+// Create or Open all tables and indexes in Database, migrated to the current schema version
+func createSchema(db *DB) {
+	migrateDatabase(db)
 }
 
-// Open CSV File for Accounts
-func importElevateAccounts(db *DB, csvFileName string) {
+// Open CSV File for Accounts, imported for day timestamp (YYYY-MM-DD). An account already imported gets
+// the mandate reference and name of the file, its former mandate references are kept as superseded
+// in elevateMandateReferences from that day on. An account whose current mandate reference was first
+// seen after that day keeps it, a file of an older day does not undo a later one.
+func importElevateAccounts(db *DB, csvFileName string, timestamp string, format inputFormat, batchSize int) {
 	fileData, err := os.Open(csvFileName)
 	if err != nil {
-		fmt.Println("Skipping Elevate Accounts file, as there is no current %s file provided....", csvFileName)
+		fmt.Printf("Skipping Elevate Accounts file, as there is no current %s file provided....\n", csvFileName)
 	} else {
 		// Read the header row
-		recordData := csv.NewReader(fileData)
-		_, err = recordData.Read()
-		if err != nil {
-			log.Fatalf("Missing header row(?): %s", err)
-		}
-
-		// prepare insert record for Accounts
+		recordData := readCSVFile(fileData, csvFileName, format)
+		fileData.Close()
+		columns := readHeader("elevate", csvFileName, recordData)
+		imported_at := timestamp
+		tx := beginImport(db, csvFileName, batchSize)
+		run := startImportRun(tx, "elevate", csvFileName, imported_at)
+
+		// prepare insert or update record for Accounts
 		SQLInsertAccountsDB := `
 			INSERT INTO elevateAccounts(
 				elevate_account_number,
 				elevate_mandate_reference,
 				elevate_customer_name       
 			) values(?, ?, ?)
+			ON CONFLICT(elevate_account_number)
+			DO UPDATE SET
+			    elevate_mandate_reference=excluded.elevate_mandate_reference,
+			    elevate_customer_name=excluded.elevate_customer_name
+		`
+		SQLcommand := tx.prepare("insert into elevateAccounts", SQLInsertAccountsDB)
+
+		// the mandate reference of an account before this import
+		SQLGetReference := `SELECT IFNULL(elevate_mandate_reference, '') FROM elevateAccounts WHERE elevate_account_number = ?`
+		getReference := tx.prepare("select from elevateAccounts", SQLGetReference)
+
+		// the day the current mandate reference of an account was first seen
+		SQLGetFirstSeen := `
+			SELECT IFNULL(MAX(first_seen_at), '')
+			FROM elevateMandateReferences
+			WHERE elevate_account_number = ? AND superseded_at = ''
+		`
+		getFirstSeen := tx.prepare("select from elevateMandateReferences", SQLGetFirstSeen)
+		var outdated int
+		var newest string
+
+		// all other references of the account are superseded by the one in the file
+		SQLSupersedeReferences := `
+			UPDATE elevateMandateReferences
+			SET superseded_at = ?
+			WHERE elevate_account_number = ? AND elevate_mandate_reference != ? AND superseded_at = ''
+		`
+		supersedeReferences := tx.prepare("update elevateMandateReferences", SQLSupersedeReferences)
+
+		SQLInsertReference := `
+			INSERT INTO elevateMandateReferences(
+				elevate_account_number,
+				elevate_mandate_reference,
+				first_seen_at,
+				last_seen_at,
+				superseded_at
+			) values(?, ?, ?, ?, '')
+			ON CONFLICT(elevate_account_number, elevate_mandate_reference)
+			DO UPDATE SET
+			    last_seen_at=MAX(last_seen_at, excluded.last_seen_at),
+			    superseded_at=''
 		`
-		SQLcommand := prepareSQL("insert into elevateAccounts", SQLInsertAccountsDB, db)
+		insertReference := tx.prepare("insert into elevateMandateReferences", SQLInsertReference)
 
 		// Loop over the records
 		for {
+			// commit a full batch before the next record
+			tx.next()
+
 			// get next record in csv file
 			record, err := recordData.Read()
 
@@ -226,59 +183,75 @@ func importElevateAccounts(db *DB, csvFileName string) {
 				break
 			}
 
-			//  Map the fields of a csv record to variables	
-			customer_account_number			 := record[0]
-			// Customer_ID	                  	 := record[1]
-			customer_name					 := record[2]
-			// Site_ID							 := record[3]
-			// site_reference					 := record[4]
-			// product_category_name			 := record[5]
-			// product_type				    	 := record[6]
-			// service_id						 := record[7]
-			// product_reference				 := record[8]
-			// supplier_name					 := record[9]
-			// override						     := record[10]
-			// start_date						 := record[11]
-			// end_date						     := record[12]
-			// rental_product_name				 := record[13]
-			// cap_price_in_pence				 := record[14]
-			// provisioning_status				 := record[15]
-			// billable						     := record[16]
-			// in_flight_order					 := record[17]
-			// force_billing					 := record[18]
-			// invoice_frequency				 := record[19]
-			// bill_initial_charges_immediately  := record[20]
-			// contractName					     := record[21]
-			// contractStartDate				 := record[22]
-			// contractEndDate					 := record[23]
-			// EtcFixed						     := record[24]
-			// EtcPercentage					 := record[25]
-			// contract_expires_in_months		 := record[26]
-			// customerContractDueRenewal		 := record[27]
-			// customerContractAutoRollOver	     := record[28]
-			// contractProfileName				 := record[29]
-			mandate_reference				 := record[30]
-			// site_address_line1                := record[31]
-			// site_address_line2                := record[32]
-			// town                              := record[33]
-			// county                            := record[34]
-			// post_code                         := record[35]
-			// country                           := record[36]
+			run.read++
+			if !run.check(columns, recordData, record, err) {
+				continue
+			}
+
+			//  Map the fields of a csv record to variables
+			customer_account_number := columns.get(record, "elevate_account_number")
+			customer_name           := columns.get(record, "elevate_customer_name")
+			mandate_reference       := columns.get(record, "elevate_mandate_reference")
+
+			// an account with a newer mandate reference than the day of the file keeps its current data
+			var first_seen_at string
+			err = getFirstSeen.QueryRow(customer_account_number).Scan(&first_seen_at)
+			if err != nil {
+				log.Fatalf("Cannot read elevateMandateReferences %s: %s", customer_account_number, err)
+			}
+			if first_seen_at > imported_at {
+				outdated++
+				if first_seen_at > newest {
+					newest = first_seen_at
+				}
+				run.skipped++
+				continue
+			}
+
+			var former_reference string
+			err = getReference.QueryRow(customer_account_number).Scan(&former_reference)
+			is_new := errors.Is(err, sql.ErrNoRows)
+			if err != nil && !is_new {
+				fmt.Println("ERROR:   Select from table elevateAccounts failed for id =", customer_account_number, err)
+				run.failed++
+				continue
+			}
 
 			_, err = SQLcommand.Exec(
 								customer_account_number   ,
 								mandate_reference         , 
 								customer_name             )
 			if err != nil {
-				if strings.Contains(fmt.Sprint(err), "UNIQUE constraint failed") {
-					// fmt.Println("SUCCESS: Skipped existing record with id:", customer_account_number)
-				} else {
-					fmt.Println("ERROR:   Insert into table elevateAccounts failed for id =", customer_account_number, err)
+				fmt.Println("ERROR:   Insert into table elevateAccounts failed for id =", customer_account_number, err)
+				run.failed++
+				continue
+			}
+			if mandate_reference != "" {
+				if _, err = supersedeReferences.Exec(imported_at, customer_account_number, mandate_reference); err == nil {
+					_, err = insertReference.Exec(customer_account_number, mandate_reference, imported_at, imported_at)
 				}
-			} else {
+				if err != nil {
+					fmt.Println("ERROR:   Insert into table elevateMandateReferences failed for id =", customer_account_number, err)
+					run.failed++
+					continue
+				}
+			}
+			if is_new {
 					fmt.Println("SUCCESS: Insert into table elevateAccounts with id:", customer_account_number)
+					run.inserted++
+			} else {
+				if former_reference != mandate_reference {
+					fmt.Println("SUCCESS: Update of table elevateAccounts with id:", customer_account_number, "mandate reference", former_reference, "->", mandate_reference)
+				}
+				run.updated++
 			}
 		}
+		if outdated > 0 {
+			fmt.Printf("WARNING: %d accounts of %s were not imported, as they have mandate references after %s already (up to %s)\n",
+				outdated, csvFileName, imported_at, newest)
+		}
+		run.finish()
+		tx.finish()
 		fmt.Println("***********************************************************")
 		fmt.Println("PROCESSING ELEVATE ACCOUNTS --   ended")
 		fmt.Println("***********************************************************")
@@ -286,20 +259,19 @@ func importElevateAccounts(db *DB, csvFileName string) {
 	}
 }
 
-// Open CSV File for CRM Accounts
-func importCRMAccounts(db *DB, csvFileName string) {
+// Open CSV File for CRM Accounts, a snapshot of the CRM on day timestamp (YYYY-MM-DD).
+// Every change of an account is kept as a new version in crmAccountHistory. After a full
+// import the accounts missing from the file are marked as deleted.
+func importCRMAccounts(db *DB, csvFileName string, timestamp string, full bool, format inputFormat, batchSize int) {
 	fileData, err := os.Open(csvFileName)
 	if err != nil {
-		fmt.Println("Skipping CRM Accounts file, as there is no current %s file provided....", csvFileName)
+		fmt.Printf("Skipping CRM Accounts file, as there is no current %s file provided....\n", csvFileName)
 	} else {
 		// process only if the CRM Accounts file exists
 		// Read the header row
-		recordData := csv.NewReader(fileData)
-		// recordData.Comma = ';'
-		_, err = recordData.Read()
-		if err != nil {
-			log.Fatalf("Missing header row(?): %s", err)
-		}
+		recordData := readCSVFile(fileData, csvFileName, format)
+		fileData.Close()
+		columns := readHeader("crm", csvFileName, recordData)
 
 		// prepare insert record for Accounts
 		SQLInsertCRMAccountsDB := `
@@ -320,12 +292,24 @@ func importCRMAccounts(db *DB, csvFileName string) {
 		    crm_premise_address=excluded.crm_premise_address,
 		    crm_stage_name=excluded.crm_stage_name,
 		    crm_gocardless_id=excluded.crm_gocardless_id,
-		    crm_zen_user_id=excluded.crm_zen_user_id
+		    crm_zen_user_id=excluded.crm_zen_user_id,
+		    crm_deleted_at=''
 		`
-		commandSQL := prepareSQL("insert into crmAccounts", SQLInsertCRMAccountsDB, db)
+		tx := beginImport(db, csvFileName, batchSize)
+		commandSQL := tx.prepare("insert into crmAccounts", SQLInsertCRMAccountsDB)
+		history := newCRMHistory(tx)
+		crmImport := newCRMImport(tx, csvFileName, timestamp, full)
+		run := startImportRun(tx, "crm", csvFileName, timestamp)
+
+		// whether an account was imported before
+		SQLGetAccount := `SELECT count(*) FROM crmAccounts WHERE crm_id = ?`
+		getAccount := tx.prepare("select from crmAccounts", SQLGetAccount)
 
 		// Loop over the records
 		for {
+			// commit a full batch before the next record
+			tx.next()
+
 			// get next record in csv file
 			record, err := recordData.Read()
 
@@ -334,19 +318,33 @@ func importCRMAccounts(db *DB, csvFileName string) {
 				break
 			}
 
-			//  Map the fields of a csv record to variables	
-			crm_account_number			  := record[0]
-			// C0UserID	                  := record[1]
-			crm_premise_address           := record[2]
-			// Premise_Type	              := record[3]
-			crm_stage_name                := record[4]
-			// Status	                  := record[5]
-			crm_name	                  := record[6]
-			crm_email	                  := record[7]
-			crm_gocardless_id             := record[8]	
-			crm_id	                      := record[9]
-			crm_zen_user_id               := record[10]
-			// Count                      := record[11]
+			run.read++
+			if !run.check(columns, recordData, record, err) {
+				continue
+			}
+
+			//  Map the fields of a csv record to variables
+			crm_account_number  := columns.get(record, "crm_account_number")
+			crm_premise_address := columns.get(record, "crm_premise_address")
+			crm_stage_name      := columns.get(record, "crm_stage_name")
+			crm_name            := columns.get(record, "crm_name")
+			crm_email           := columns.get(record, "crm_email")
+			crm_gocardless_id   := columns.get(record, "crm_gocardless_id")
+			crm_id              := columns.get(record, "crm_id")
+			crm_zen_user_id     := columns.get(record, "crm_zen_user_id")
+			crmImport.see(crm_id)
+
+			// an account with a newer version than the day of the file keeps its current data
+			if history.outdated(crm_id, timestamp) {
+				run.skipped++
+				continue
+			}
+
+			var existing int
+			err = getAccount.QueryRow(crm_id).Scan(&existing)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				log.Fatalf("Cannot read crmAccounts %s: %s", crm_id, err)
+			}
 
 			_, err = commandSQL.Exec(
 						crm_id,
@@ -363,10 +361,33 @@ func importCRMAccounts(db *DB, csvFileName string) {
 				} else {
 					fmt.Println("ERROR:   Insert into table crmAccounts failed for id =", crm_account_number, crm_id, err)
 				}
+				run.failed++
 			} else {
 					fmt.Println("SUCCESS: Insert into table crmAccounts with id:", crm_account_number, crm_id)
+					if existing > 0 {
+						run.updated++
+					} else {
+						run.inserted++
+					}
+					err = history.record(crmAccount{
+						crm_account_number:  crm_account_number,
+						crm_id:              crm_id,
+						crm_name:            crm_name,
+						crm_email:           crm_email,
+						crm_premise_address: crm_premise_address,
+						crm_stage_name:      crm_stage_name,
+						crm_gocardless_id:   crm_gocardless_id,
+						crm_zen_user_id:     crm_zen_user_id,
+					}, timestamp)
+					if err != nil {
+						fmt.Println("ERROR:   Insert into table crmAccountHistory failed for id =", crm_account_number, crm_id, err)
+					}
 			}
 		}
+		history.warnOutdated(csvFileName, timestamp)
+		crmImport.finish(run.rejected)
+		run.finish()
+		tx.finish()
 	}
 	fmt.Println("***********************************************************")
 	fmt.Println("PROCESSING ELEVATE CRM ACCOUNTS --   ended")
@@ -374,19 +395,18 @@ func importCRMAccounts(db *DB, csvFileName string) {
 	fmt.Println(" ")
 }
 
-// import mandate events data from specifice file
-func importMandateEvents (db *DB, csvFileName string) {
-	var timestamp = time.Now().Format("2006-01-02")
+// import mandate events data from specifice file, stamped as imported on day timestamp (YYYY-MM-DD)
+func importMandateEvents (db *DB, csvFileName string, timestamp string, format inputFormat, batchSize int) {
 	fileData, err := os.Open(csvFileName)
 	if err != nil {
-		fmt.Println("Skipping Mandate Events file, as there is no current %s file provided....", csvFileName)
+		fmt.Printf("Skipping Mandate Events file, as there is no current %s file provided....\n", csvFileName)
 	} else {
 		// Read the header row
-		recordData := csv.NewReader(fileData)
-		_, err = recordData.Read()
-		if err != nil {
-			log.Fatalf("Missing header row(?): %s %s", csvFileName, err)
-		}
+		recordData := readCSVFile(fileData, csvFileName, format)
+		fileData.Close()
+		columns := readHeader("mandates", csvFileName, recordData)
+		tx := beginImport(db, csvFileName, batchSize)
+		run := startImportRun(tx, "mandates", csvFileName, timestamp)
 
 		// prepare insert record for mandateEvents
 		SQLInsertMandateEventsDB := `
@@ -425,10 +445,13 @@ func importMandateEvents (db *DB, csvFileName string) {
 			customers_name
 		) values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		`
-		commandSQL := prepareSQL("insert into mandateEvents", SQLInsertMandateEventsDB, db)
+		commandSQL := tx.prepare("insert into mandateEvents", SQLInsertMandateEventsDB)
 
 		// Loop over the records
 		for {
+			// commit a full batch before the next record
+			tx.next()
+
 			// get next record in csv file
 			record, err := recordData.Read()
 
@@ -437,37 +460,42 @@ func importMandateEvents (db *DB, csvFileName string) {
 				break
 			}
 
-			//  Map the fields of a csv record to variables	
-			id	 									:= record[0]
-			created_at	 							:= record[1]
-			resource_type	 						:= record[2]
-			action	 								:= record[3]
-			details_origin	 						:= record[4]
-			details_cause	 						:= record[5]
-			details_description	 					:= record[6]
-			details_scheme	 						:= record[7]
-			details_reason_code	 					:= record[8]
-			links_previous_customer_bank_account	:= record[9]
-			links_new_customer_bank_account	 		:= record[10]
-			links_parent_event	 					:= record[11]
-			links_mandate	 						:= record[12]
-			mandates_id	 							:= record[13]
-			mandates_created_at	 					:= record[14]
-			mandates_reference	 					:= record[15]
-			mandates_status	 						:= record[16]
-			mandates_scheme	 						:= record[17]
-			mandates_next_possible_charge_date	  	:= record[18]
-			mandates_payments_require_approval	  	:= record[19]
-			mandates_links_customer_bank_account  	:= record[20]
-			mandates_links_creditor	             	:= record[21]
-			customers_id	 				      	:= record[22]
-			customers_given_name	 			  	:= record[23]
-			customers_family_name	 			  	:= record[24]
-			customers_company_name	 			  	:= record[25]
-			customers_metadata_leadID	 		  	:= record[26]
-			customers_metadata_link	 			  	:= record[27]
-			customers_metadata_xero	 			  	:= "" //record[28]
-			mandates_metadata_xero 				  	:= "" //record[29]
+			run.read++
+			if !run.check(columns, recordData, record, err) {
+				continue
+			}
+
+			//  Map the fields of a csv record to variables
+			id                                      := columns.get(record, "id")
+			created_at                              := columns.get(record, "created_at")
+			resource_type                           := columns.get(record, "resource_type")
+			action                                  := columns.get(record, "action")
+			details_origin                          := columns.get(record, "details_origin")
+			details_cause                           := columns.get(record, "details_cause")
+			details_description                     := columns.get(record, "details_description")
+			details_scheme                          := columns.get(record, "details_scheme")
+			details_reason_code                     := columns.get(record, "details_reason_code")
+			links_previous_customer_bank_account    := columns.get(record, "links_previous_customer_bank_account")
+			links_new_customer_bank_account         := columns.get(record, "links_new_customer_bank_account")
+			links_parent_event                      := columns.get(record, "links_parent_event")
+			links_mandate                           := columns.get(record, "links_mandate")
+			mandates_id                             := columns.get(record, "mandates_id")
+			mandates_created_at                     := columns.get(record, "mandates_created_at")
+			mandates_reference                      := columns.get(record, "mandates_reference")
+			mandates_status                         := columns.get(record, "mandates_status")
+			mandates_scheme                         := columns.get(record, "mandates_scheme")
+			mandates_next_possible_charge_date      := columns.get(record, "mandates_next_possible_charge_date")
+			mandates_payments_require_approval      := columns.get(record, "mandates_payments_require_approval")
+			mandates_links_customer_bank_account    := columns.get(record, "mandates_links_customer_bank_account")
+			mandates_links_creditor                 := columns.get(record, "mandates_links_creditor")
+			customers_id                            := columns.get(record, "customers_id")
+			customers_given_name                    := columns.get(record, "customers_given_name")
+			customers_family_name                   := columns.get(record, "customers_family_name")
+			customers_company_name                  := columns.get(record, "customers_company_name")
+			customers_metadata_leadID               := columns.get(record, "customers_metadata_leadID")
+			customers_metadata_link                 := columns.get(record, "customers_metadata_link")
+			customers_metadata_xero                 := columns.get(record, "customers_metadata_xero")
+			mandates_metadata_xero                  := columns.get(record, "mandates_metadata_xero")
 			imported_at                             := timestamp
 			customers_name                          := customers_given_name + " " + customers_family_name
 
@@ -508,14 +536,19 @@ func importMandateEvents (db *DB, csvFileName string) {
 			if err != nil {
 				if strings.Contains(fmt.Sprint(err), "UNIQUE constraint failed: mandateEvents.id") {
 					fmt.Println("SUCCESS: Skipped existing record mandateEvents with id:", id)
+					run.skipped++
 				} else {
 					fmt.Println("ERROR:   Insert into table mandateEvents failed for id =", id, err)
+					run.failed++
 				}
 			} else {
 					fmt.Println("SUCCESS: Insert into table mandateEvents with id:", id)
+					run.inserted++
 			}	
 
 		} // for loop
+		run.finish()
+		tx.finish()
 	} // if data
 	fmt.Println("***********************************************************")
 	fmt.Println("PROCESSING CANCELLED OR FAILED MANDATES          --   ended")
@@ -523,374 +556,275 @@ func importMandateEvents (db *DB, csvFileName string) {
 	fmt.Println(" ")
 } // func
 
-// process mandate events for today's records
-func processMandateEvents(db *DB, csvPreTeamTo string, csvPostTeamTo string, csvOtherTeamTo string) {
-	var timestamp = time.Now().Format("2006-01-02")
-	tx, err := db.Begin()
-
-	headerText := "id,created_at,resource_type,action,details_origin,details_cause,details_description,details_scheme,details_reason_code,links_previous_customer_bank_account,links_new_customer_bank_account,links_parent_event,links_mandate,mandates_id,mandates_created_at,mandates_reference,mandates_status,mandates_scheme,mandates_next_possible_charge_date,mandates_payments_require_approval,mandates_links_customer_bank_account,mandates_links_creditor,customers_id,customers_given_name,customers_family_name,customers_company_name,customers_metadata_leadID,customers_metadata_link,customers_metadata_xero,mandates_metadata_xero,imported_at,customers_name,crm_account_number,crm_id,crm_name,crm_email,crm_premise_address,crm_stage_name,crm_customer_name,crm_gocardless_id,target_team,crm_zen_user_id\n"
+// A failed or cancelled mandate event as stored in table mandateEvents
+type mandateEvent struct {
+	id                                       string
+	created_at                               string
+	resource_type                            string
+	action                                   string
+	details_origin                           string
+	details_cause                            string
+	details_description                      string
+	details_scheme                           string
+	details_reason_code                      string
+	links_previous_customer_bank_account     string
+	links_new_customer_bank_account          string
+	links_parent_event                       string
+	links_mandate                            string
+	mandates_id                              string
+	mandates_created_at                      string
+	mandates_reference                       string
+	mandates_status                          string
+	mandates_scheme                          string
+	mandates_next_possible_charge_date       string
+	mandates_payments_require_approval       string
+	mandates_links_customer_bank_account     string
+	mandates_links_creditor                  string
+	customers_id                             string
+	customers_given_name                     string
+	customers_family_name                    string
+	customers_company_name                   string
+	customers_metadata_leadID                string
+	customers_metadata_link                  string
+	customers_metadata_xero                  string
+	mandates_metadata_xero                   string
+	imported_at                              string
+	customers_name                           string
+}
 
-	SQLTodaysMandateEvents := fmt.Sprintf(`
-		SELECT DISTINCT
-			id 										,	
-			created_at 								,	
-			resource_type 							,	
-			action 									,	
-			details_origin 							,	
-			details_cause 							,	
-			details_description	        			,	
-			details_scheme 							,	
-			details_reason_code 					,	
-			links_previous_customer_bank_account	,	
-			links_new_customer_bank_account 		,	
-			links_parent_event 						,	
-			links_mandate 							,	
-			mandates_id 							,	
-			mandates_created_at 					,	
-			mandates_reference 						,	
-			mandates_status 						,	
-			mandates_scheme 						,	
-			mandates_next_possible_charge_date   	,	
-			mandates_payments_require_approval   	,	
-			mandates_links_customer_bank_account 	,	
-			mandates_links_creditor 				,	
-			customers_id							,
-			customers_given_name 					,	
-			customers_family_name 					,	
-			customers_company_name 					,	
-			customers_metadata_leadID 				,	
-			customers_metadata_link 				,	
-			customers_metadata_xero 				,	
-			mandates_metadata_xero 					,
-			imported_at                             ,
+// Columns of table mandateEvents in the order of scanMandateEvent
+const mandateEventColumns = `
+			id,
+			created_at,
+			resource_type,
+			action,
+			details_origin,
+			details_cause,
+			details_description,
+			details_scheme,
+			details_reason_code,
+			links_previous_customer_bank_account,
+			links_new_customer_bank_account,
+			links_parent_event,
+			links_mandate,
+			mandates_id,
+			mandates_created_at,
+			mandates_reference,
+			mandates_status,
+			mandates_scheme,
+			mandates_next_possible_charge_date,
+			mandates_payments_require_approval,
+			mandates_links_customer_bank_account,
+			mandates_links_creditor,
+			customers_id,
+			customers_given_name,
+			customers_family_name,
+			customers_company_name,
+			customers_metadata_leadID,
+			customers_metadata_link,
+			customers_metadata_xero,
+			mandates_metadata_xero,
+			imported_at,
 			customers_name
-		FROM mandateEvents
-		WHERE imported_at = "%s"
-	`, timestamp)
+`
+
+// Scan a row selected with mandateEventColumns into a mandateEvent
+func scanMandateEvent(row *sql.Rows) (mandateEvent, error) {
+	var e mandateEvent
+	err := row.Scan(
+			&e.id,
+			&e.created_at,
+			&e.resource_type,
+			&e.action,
+			&e.details_origin,
+			&e.details_cause,
+			&e.details_description,
+			&e.details_scheme,
+			&e.details_reason_code,
+			&e.links_previous_customer_bank_account,
+			&e.links_new_customer_bank_account,
+			&e.links_parent_event,
+			&e.links_mandate,
+			&e.mandates_id,
+			&e.mandates_created_at,
+			&e.mandates_reference,
+			&e.mandates_status,
+			&e.mandates_scheme,
+			&e.mandates_next_possible_charge_date,
+			&e.mandates_payments_require_approval,
+			&e.mandates_links_customer_bank_account,
+			&e.mandates_links_creditor,
+			&e.customers_id,
+			&e.customers_given_name,
+			&e.customers_family_name,
+			&e.customers_company_name,
+			&e.customers_metadata_leadID,
+			&e.customers_metadata_link,
+			&e.customers_metadata_xero,
+			&e.mandates_metadata_xero,
+			&e.imported_at,
+			&e.customers_name)
+	return e, err
+}
 
-	// prepare file "mandates-to-process-by-pre-installation-team-YYYY-MM-DD.csv"
-	targetFilePreTeam, err := os.OpenFile(csvPreTeamTo, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
-	if err != nil {
-		panic(err)
-	}
-	defer targetFilePreTeam.Close()
-	if _, err = targetFilePreTeam.WriteString(headerText); err != nil {
-		panic(err)
+// "yes" for flags in the exports, empty otherwise
+func yesOrEmpty(flag bool) string {
+	if flag {
+		return "yes"
 	}
+	return ""
+}
 
-	// prepare file "mandates-to-process-by-post-installation-team-YYYY-MM-DD.csv"
-	targetFilePostTeam, err := os.OpenFile(csvPostTeamTo, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
-	if err != nil {
-		panic(err)
-	}
-	defer targetFilePostTeam.Close()
-	if _, err = targetFilePostTeam.WriteString(headerText); err != nil {
-		panic(err)
-	}
+// process mandate events imported on day timestamp (YYYY-MM-DD): group them into cases,
+// find their CRM accounts and route them with the routing rules, stored in caseRoutings
+func processMandateEvents(db *DB, timestamp string, routing *routingConfig, fuzzyThreshold float64) {
+	var runTimestamp = time.Now().Format("2006-01-02 15:04:05")
 
-	// prepare file "mandates-to-process-by-post-installation-team-YYYY-MM-DD.csv"
-	targetFileOthers, err := os.OpenFile(csvOtherTeamTo, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
-	if err != nil {
-		panic(err)
-	}
-	defer targetFileOthers.Close()
-	if _, err = targetFileOthers.WriteString(headerText); err != nil {
-		panic(err)
-	}
+	SQLTodaysMandateEvents := `
+		SELECT DISTINCT ` + mandateEventColumns + `
+		FROM mandateEvents
+		WHERE imported_at = ?
+		ORDER BY created_at, id
+	`
 
-	row, err := tx.Query(SQLTodaysMandateEvents)
+	matcher := newMatcher(db, fuzzyThreshold)
+	defer matcher.close()
+
+	// read the day's events completely, before the match results are written
+	row, err := db.Query(SQLTodaysMandateEvents, timestamp)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer row.Close()
-
+	var events []mandateEvent
 	for row.Next() {
-			var id 										string 	 
-			var created_at 								string 	 
-			var resource_type 							string 	 
-			var action 									string 	 
-			var details_origin 							string 	 
-			var details_cause 							string 	 
-			var details_description	        			string 	 
-			var details_scheme 							string 	 
-			var details_reason_code 					string 	 
-			var links_previous_customer_bank_account	string 	 
-			var links_new_customer_bank_account 		string 	 
-			var links_parent_event 						string 	 
-			var links_mandate 							string 	 
-			var mandates_id 							string 	 
-			var mandates_created_at 					string 	 
-			var mandates_reference 						string 	 
-			var mandates_status 						string 	 
-			var mandates_scheme 						string 	 
-			var mandates_next_possible_charge_date   	string 	 
-			var mandates_payments_require_approval   	string 	 
-			var mandates_links_customer_bank_account 	string 	 
-			var mandates_links_creditor 				string 	 
-			var customers_id							string  
-			var customers_given_name 					string 	 
-			var customers_family_name 					string 	 
-			var customers_company_name 					string 	 
-			var customers_metadata_leadID 				string 	 
-			var customers_metadata_link 				string 	 
-			var customers_metadata_xero 				string 	 
-			var mandates_metadata_xero 					string  
-			var imported_at                             string
-			var customers_name                          string
-
-			row.Scan(
-				&id 									,	
-				&created_at 							,	
-				&resource_type 							,	
-				&action 								,	
-				&details_origin 						,	
-				&details_cause 							,	
-				&details_description	        		,	
-				&details_scheme 						,	
-				&details_reason_code 					,	
-				&links_previous_customer_bank_account	,	
-				&links_new_customer_bank_account 		,	
-				&links_parent_event 					,	
-				&links_mandate 							,	
-				&mandates_id 							,	
-				&mandates_created_at 					,	
-				&mandates_reference 					,	
-				&mandates_status 						,	
-				&mandates_scheme 						,	
-				&mandates_next_possible_charge_date   	,	
-				&mandates_payments_require_approval   	,	
-				&mandates_links_customer_bank_account 	,	
-				&mandates_links_creditor 				,	
-				&customers_id							,
-				&customers_given_name 					,	
-				&customers_family_name 					,	
-				&customers_company_name 				,	
-				&customers_metadata_leadID 				,	
-				&customers_metadata_link 				,	
-				&customers_metadata_xero 				,	
-				&mandates_metadata_xero 				,
-				&imported_at                            ,
-			    &customers_name                          )
-			
-			// fields to determine
-			var crm_account_number  string
-			var crm_id              string
-			var crm_name            string
-			var crm_email           string
-			var crm_premise_address string
-			var crm_stage_name      string
-			var crm_customer_name   string
-			var crm_gocardless_id   string
-			var target_team         string
-			var crm_zen_user_id     string
-			fmt.Println(id, customers_name)
-
-			var found = false
-
-			// Method 1: customers_metadata_leadID is a CRM account number?
-			if found == false {
-				if customers_metadata_leadID != "" {
-					SQLGetCRMAccount := fmt.Sprintf(`SELECT DISTINCT crm_account_number, crm_id, crm_name, crm_email, crm_premise_address, crm_stage_name, crm_gocardless_id, crm_zen_user_id
-						FROM crmAccounts 
-						WHERE ( crm_id             = "%s"
-						  OR    crm_account_number = "%s"
-						)`, strings.TrimSpace(customers_metadata_leadID), strings.TrimSpace(customers_metadata_leadID))
-					row, err := db.Query(SQLGetCRMAccount)
-					if err == nil {
-						for row.Next() {
-							row.Scan(&crm_account_number,&crm_id,&crm_name,&crm_email,&crm_premise_address,&crm_stage_name,&crm_gocardless_id,&crm_zen_user_id)
-							if crm_id != "" || crm_account_number != "" {
-								found = true
-								break
-							}
-						}
-					} 
-					defer row.Close()
-				}
-				if found {
-					fmt.Println("Method 1: customers_metadata_leadID:", customers_metadata_leadID, " found crm_id:", crm_id, " crm_account_number: ", crm_account_number) 
-				} else {
-					fmt.Println("Method 1: customers_metadata_leadID:", customers_metadata_leadID, " didn't find a crm record", crm_id, crm_account_number)
+		e, err := scanMandateEvent(row)
+		if err != nil {
+			log.Fatal(err)
+		}
+		events = append(events, e)
+	}
+	row.Close()
+
+	// one routing per case, for the latest of the day's events of a mandate,
+	// and for the active cases of earlier days with their latest event
+	clearCaseRoutings(db, timestamp)
+	cases := groupCases(db, events)
+	cases = append(cases, carriedForwardCases(db, timestamp, cases)...)
+	for _, c := range cases {
+			var match matchResult
+			for _, e := range c.events {
+				if c.carried && e != c.latestEvent() {
+					continue
 				}
+				fmt.Println(e.id, e.customers_name, "case:", c.case_id, c.caseType(), c.status)
+				match = matcher.matchCRMAccount(e)
+				storeMatchResult(db, e.id, match, runTimestamp)
 			}
+			e := c.latestEvent()
 
-			// Method 2: mandates_id is valid CRM account number
-			if found == false {
-				if mandates_id != "" {
-					SQLGetCRMAccount := fmt.Sprintf(`SELECT DISTINCT crm_account_number, crm_id, crm_name, crm_email, crm_premise_address, crm_stage_name, crm_gocardless_id, crm_zen_user_id
-						FROM elevateAccounts
-						INNER JOIN crmAccounts 
-						ON elevate_account_number = crm_account_number
-						WHERE elevate_mandate_reference = "%s"`, strings.TrimSpace(mandates_id))
-					row, err := db.Query(SQLGetCRMAccount)
-					if err == nil {
-						for row.Next() {
-							row.Scan(&crm_account_number,&crm_id,&crm_name,&crm_email,&crm_premise_address,&crm_stage_name,&crm_gocardless_id,&crm_zen_user_id)
-							if crm_id != "" || crm_account_number != "" {
-								found = true
-								break
-							}
-						}
-					} 
-					defer row.Close()
-				}
-				if found {
-					fmt.Println("Method 2: mandates_id:", mandates_id, " found crm_id:", crm_id, " crm_account_number: ", crm_account_number) 
-				} else {
-					fmt.Println("Method 2: mandates_id:", mandates_id, " didn't find a crm record", crm_id, crm_account_number)
-				}
-			}
+			// the routing rules see the CRM stage of the account on the day of the event
+			match.account.crm_stage_name = matcher.stageAt(match.account, e.created_at)
 
-			// Match with Method 3: customers_id is valid CRM account number
-			if found == false {
-				if customers_id != "" {
-					SQLGetCRMAccount := fmt.Sprintf(`SELECT DISTINCT crm_account_number, crm_id, crm_name, crm_email, crm_premise_address, crm_stage_name, crm_gocardless_id, crm_zen_user_id
-						FROM crmAccounts 
-						WHERE crm_gocardless_id = "%s"`, strings.TrimSpace(customers_id))
-					row, err := db.Query(SQLGetCRMAccount)
-					if err == nil {
-						for row.Next() {
-							row.Scan(&crm_account_number,&crm_id,&crm_name,&crm_email,&crm_premise_address,&crm_stage_name,&crm_gocardless_id,&crm_zen_user_id)
-							if crm_id != "" || crm_account_number != "" {
-								found = true
-								break
-							}
-						}
-					} 
-					defer row.Close()
-				}
-				if found {
-					fmt.Println("Method 3: customers_id:", customers_id, " found crm_id:", crm_id, " crm_account_number: ", crm_account_number) 
-				} else {
-					fmt.Println("Method 3: customers_id:", customers_id, " didn't find a crm record", crm_id, crm_account_number)
-				}
+			// cases cm can close itself go to the auto-resolved file, the others are
+			// given to a team with the routing rules
+			target_team, routing_rule := autoResolvedTeam, "auto-resolved"
+			auto_resolved_reason := autoResolveReason(db, c, timestamp)
+			if auto_resolved_reason != "" {
+				autoResolveCase(db, c, auto_resolved_reason)
+			} else {
+				target_team, routing_rule = routing.route(e, match)
 			}
+			fmt.Println("Team:", target_team, " rule:", routing_rule)
+			storeCaseRouting(db, timestamp, c, e.id, target_team, routing_rule, auto_resolved_reason, match.account.crm_stage_name, runTimestamp)
+			fmt.Println(" ")
+	}
+	fmt.Println("***********************************************************")
+	fmt.Println("PROCESSING MANDATE CASES                         --   ended")
+	fmt.Println("***********************************************************")
+	fmt.Println(" ")
+}
 
-			// Match with Method 4: customers_id is valid CRM account number
-			if found == false {
-				if customers_name != "" {
-					SQLGetCRMAccount := fmt.Sprintf(`SELECT DISTINCT crm_account_number, crm_id, crm_name, crm_email, crm_premise_address, crm_stage_name, crm_gocardless_id, crm_zen_user_id
-						FROM crmAccounts 
-						WHERE crm_name = "%s"`, strings.TrimSpace(customers_name))
-					row, err := db.Query(SQLGetCRMAccount)
-					if err == nil {
-						for row.Next() {
-							row.Scan(&crm_account_number,&crm_id,&crm_name,&crm_email,&crm_premise_address,&crm_stage_name,&crm_gocardless_id,&crm_zen_user_id)
-							if crm_id != "" || crm_account_number != "" {
-								found = true
-								break
-							}
-						}
-					} 
-					defer row.Close()
-				}
-				if found {
-					fmt.Println("Method 4: customers_name:", customers_name, " found crm_id:", crm_id, " crm_account_number: ", crm_account_number) 
-				} else {
-					fmt.Println("Method 4: customers_name:", customers_name, " didn't find a crm record", crm_id, crm_account_number)
-				}
-			}
+// Input and output files of one processing day
+type dailyFiles struct {
+	csvAccountsFrom   string
+	csvCRMFrom        string
+	csvCancelledFrom  string
+	csvFailedFrom     string
+	csvPaymentsFrom   string
+	csvPreTeamTo      string
+	csvPostTeamTo     string
+	csvOtherTeamTo    string
+	csvAutoResolvedTo string
+	csvSuspendTo      string
+}
 
-			// determine processing team depending on the stage
-			switch crm_stage_name {
-				case "N/A": 			target_team = "Pre-Installation"
-				case "SOLD": 			target_team = "Pre-Installation"
-				case "INSTALL":			target_team = "Pre-Installation"
-				case "PROVISIONING":	target_team = "Post-Installation"
-				case "INVOICING":		target_team = "Post-Installation"
-				case "ACTIVE":			target_team = "Post-Installation"
-				case "INACTIVE":		target_team = "No action - Inactive"
-				default:    			target_team = "Pre-Installation"
-			}
+// Default file names for a day (YYYY-MM-DD) in the directory of the executable.
+// The files of the teams come from the routing rules.
+func defaultDailyFiles(current_path string, timestamp string) dailyFiles {
+	return dailyFiles{
+		csvAccountsFrom:  filepath.Join( current_path, "elevate-accounts-"                               + timestamp + ".csv" ),
+		csvCRMFrom:       filepath.Join( current_path, "crm-accounts-"                                   + timestamp + ".csv" ),
+		csvCancelledFrom: filepath.Join( current_path, "cancelled-mandates-"                             + timestamp + ".csv" ),
+		csvFailedFrom:    filepath.Join( current_path, "failed-mandates-"                                + timestamp + ".csv" ),
+		csvPaymentsFrom:  filepath.Join( current_path, "failed-payments-"                                + timestamp + ".csv" ),
+		csvOtherTeamTo:   filepath.Join( current_path, "mandates-to-check-"                              + timestamp + ".csv" ),
+		csvAutoResolvedTo: filepath.Join( current_path, "mandates-auto-resolved-"                       + timestamp + ".csv" ),
+		csvSuspendTo:     filepath.Join( current_path, "customers-to-suspend-"                           + timestamp + ".csv" ),
+	}
+}
 
-			// determine special case for "at your request"
-			if strings.Contains(details_description, "at your request") {
-				target_team = "No action - at our request"
-			}
+// Days to process: a single -date, every day from -from to -to, or today
+func processingDays(date string, dateFrom string, dateTo string) []string {
+	today := time.Now().Format("2006-01-02")
+	if date != "" && (dateFrom != "" || dateTo != "") {
+		log.Fatalf("Use either -date or -from/-to, not both")
+	}
+	if dateFrom == "" && dateTo == "" {
+		if date == "" {
+			date = today
+		}
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			log.Fatalf("Invalid -date %s, expected YYYY-MM-DD: %s", date, err)
+		}
+		return []string{date}
+	}
 
-			resultRow := 	"\"" + id + "\"," +
-							"\"" + created_at + "\"," +
-							"\"" + resource_type + "\"," +
-							"\"" + action + "\"," +
-							"\"" + details_origin + "\"," +
-							"\"" + details_cause + "\"," +
-							"\"" + details_description + "\"," +
-							"\"" + details_scheme + "\"," +
-							"\"" + details_reason_code + "\"," +
-							"\"" + links_previous_customer_bank_account + "\"," +
-							"\"" + links_new_customer_bank_account + "\"," +
-							"\"" + links_parent_event + "\"," +
-							"\"" + links_mandate + "\"," +
-							"\"" + mandates_id + "\"," +
-							"\"" + mandates_created_at + "\"," +
-							"\"" + mandates_reference + "\"," +
-							"\"" + mandates_status + "\"," +
-							"\"" + mandates_scheme + "\"," +
-							"\"" + mandates_next_possible_charge_date + "\"," +
-							"\"" + mandates_payments_require_approval + "\"," +
-							"\"" + mandates_links_customer_bank_account + "\"," +
-							"\"" + mandates_links_creditor + "\"," +
-							"\"" + customers_id + "\"," +
-							"\"" + customers_given_name + "\"," +
-							"\"" + customers_family_name + "\"," +
-							"\"" + customers_company_name + "\"," +
-							"\"" + customers_metadata_leadID + "\"," +
-							"\"" + customers_metadata_link + "\"," +
-							"\"" + customers_metadata_xero + "\"," +
-							"\"" + mandates_metadata_xero + "\"," +
-							"\"" + imported_at + "\"," +
-							"\"" + customers_name + "\"," +
-							"\"" + crm_account_number + "\"," +
-							"\"" + crm_id + "\"," +
-							"\"" + crm_name + "\"," +
-							"\"" + crm_email + "\"," +
-							"\"" + crm_premise_address + "\"," +
-							"\"" + crm_stage_name + "\"," +
-							"\"" + crm_customer_name + "\"," +
-							"\"" + crm_gocardless_id + "\"," +
-							"\"" + target_team + "\"," +
-							"\"" + crm_zen_user_id  + "\"\n"
-
-				if target_team == "Pre-Installation" {
-					if _, err = targetFilePreTeam.WriteString(resultRow); err != nil {
-						panic(err)
-					}
-				} else if target_team == "Post-Installation" {
-					if _, err = targetFilePostTeam.WriteString(resultRow); err != nil {
-						panic(err)
-					}
-				} else {
-					if _, err = targetFileOthers.WriteString(resultRow); err != nil {
-						panic(err)
-					}
-				}
-				fmt.Println(" \n")
+	if dateTo == "" {
+		dateTo = today
+	}
+	from, err := time.Parse("2006-01-02", dateFrom)
+	if err != nil {
+		log.Fatalf("Invalid -from %s, expected YYYY-MM-DD: %s", dateFrom, err)
+	}
+	to, err := time.Parse("2006-01-02", dateTo)
+	if err != nil {
+		log.Fatalf("Invalid -to %s, expected YYYY-MM-DD: %s", dateTo, err)
+	}
+	if to.Before(from) {
+		log.Fatalf("-to %s is before -from %s", dateTo, dateFrom)
+	}
+	var days []string
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		days = append(days, day.Format("2006-01-02"))
 	}
-	tx.Commit()
-	targetFilePreTeam.Close()
-	targetFilePostTeam.Close()
-	targetFileOthers.Close()
+	return days
 }
 
-func main() {
+// cm run: import the day's files, process them and export the team files, for one or more days
+func runCommand(args []string, current_path string, defaultDatabaseName string) {
 	var dbName string
-	var csvAccountsFrom string
-	var csvCRMFrom string
-	var csvCancelledFrom string
-	var csvFailedFrom string
-	var csvPreTeamTo string
-	var csvPostTeamTo string
-	var csvOtherTeamTo string
-	var timestamp = time.Now().Format("2006-01-02")
-	var current_path = getCurrentPath()
-	var defaultDatabaseName          = filepath.Join( current_path, "cancelled-mandates-database.sqlite3"                  )
-	var defaultAccountsFileName      = filepath.Join( current_path, "elevate-accounts-"                               + timestamp + ".csv" )
-	var defaultCRMFileName           = filepath.Join( current_path, "crm-accounts-"                                   + timestamp + ".csv" )
-	var defaultFromCancelledFileName = filepath.Join( current_path, "cancelled-mandates-"                             + timestamp + ".csv" )
-	var defaultFromFailedFileName    = filepath.Join( current_path, "failed-mandates-"                                + timestamp + ".csv" )
-	var defaultToPreFileName         = filepath.Join( current_path, "mandates-to-process-by-pre-installation-team-"   + timestamp + ".csv" )
-	var defaultToPostFileName        = filepath.Join( current_path, "mandates-to-process-by-post-installation-team-"  + timestamp + ".csv" )
-	var defaultToOthersFileName      = filepath.Join( current_path, "mandates-to-check-"                              + timestamp + ".csv" )
+	var files dailyFiles
+	var date string
+	var dateFrom string
+	var dateTo string
+	var columnsFrom string
+	var rulesFrom string
+	var fuzzyThreshold float64
+	var countSuspend int
+	var crmPartial bool
+	var batchSize int
+	var delimiter string
+	var options exportOptions
 
 	fmt.Println(" ")
 	fmt.Println("***********************************************************")
@@ -898,49 +832,154 @@ func main() {
 	fmt.Println("***********************************************************")
 	
 	// get command-line parameters or use defaults
-	flag.StringVar(&dbName,               "db",        defaultDatabaseName,          "Sqlite database to import to"    )
-	flag.StringVar(&csvAccountsFrom,      "elevate",   defaultAccountsFileName,      "CSV file to import accounts from")
-	flag.StringVar(&csvCRMFrom,           "crm",       defaultCRMFileName,           "CSV file to import crm from"     )
-	flag.StringVar(&csvCancelledFrom,     "cancelled", defaultFromCancelledFileName, "CSV file to import from"         )
-	flag.StringVar(&csvFailedFrom,        "failed",    defaultFromFailedFileName,    "CSV file to import from"         )
-	flag.StringVar(&csvPreTeamTo,         "toPre",     defaultToPreFileName,         "CSV file pre-processing-team  to export result to")
-	flag.StringVar(&csvPostTeamTo,        "toPost",    defaultToPostFileName,        "CSV file post-processing-team to export result to")
-	flag.StringVar(&csvOtherTeamTo,       "toCheck",   defaultToOthersFileName,      "CSV file to-check             to export result to")
-
-	flag.Parse()
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	flags.StringVar(&dbName,                 "db",        defaultDatabaseName, "Sqlite database to import to"    )
+	flags.StringVar(&date,                   "date",      "",                  "Day to process as YYYY-MM-DD (default today)")
+	flags.StringVar(&dateFrom,               "from",      "",                  "First day to process as YYYY-MM-DD, for a range of days")
+	flags.StringVar(&dateTo,                 "to",        "",                  "Last day to process as YYYY-MM-DD, for a range of days (default today)")
+	flags.StringVar(&files.csvAccountsFrom,  "elevate",   "",                  "CSV file to import accounts from (default elevate-accounts-YYYY-MM-DD.csv)")
+	flags.StringVar(&files.csvCRMFrom,       "crm",       "",                  "CSV file to import crm from (default crm-accounts-YYYY-MM-DD.csv)")
+	flags.BoolVar(&crmPartial,               "crm-partial", false,             "The CRM files hold only some accounts: don't mark the accounts missing from them as deleted")
+	flags.StringVar(&files.csvCancelledFrom, "cancelled", "",                  "CSV file to import from (default cancelled-mandates-YYYY-MM-DD.csv)")
+	flags.StringVar(&files.csvFailedFrom,    "failed",    "",                  "CSV file to import from (default failed-mandates-YYYY-MM-DD.csv)")
+	flags.StringVar(&files.csvPaymentsFrom,  "payments",  "",                  "CSV file to import failed payments from (default failed-payments-YYYY-MM-DD.csv)")
+	flags.StringVar(&files.csvPreTeamTo,     "toPre",     "",                  "CSV file pre-processing-team  to export result to (default mandates-to-process-by-pre-installation-team-YYYY-MM-DD.csv)")
+	flags.StringVar(&files.csvPostTeamTo,    "toPost",    "",                  "CSV file post-processing-team to export result to (default mandates-to-process-by-post-installation-team-YYYY-MM-DD.csv)")
+	flags.StringVar(&files.csvOtherTeamTo,   "toCheck",   "",                  "CSV file to-check             to export result to (default mandates-to-check-YYYY-MM-DD.csv)")
+	flags.StringVar(&files.csvAutoResolvedTo, "toAutoResolved", "",            "CSV file cases closed by cm   to export result to (default mandates-auto-resolved-YYYY-MM-DD.csv)")
+	flags.StringVar(&files.csvSuspendTo,     "toSuspend", "",                  "CSV file customers to suspend to export result to (default customers-to-suspend-YYYY-MM-DD.csv)")
+	flags.IntVar(&countSuspend,              "count-suspend", 4,               "Number of failed payment requests from which a payment is suspended")
+	flags.StringVar(&columnsFrom,            "columns",   "",                  "JSON file with additional header names per csv column")
+	flags.StringVar(&rulesFrom,              "rules",     "",                  "JSON file with teams and routing rules (default: built-in rules)")
+	flags.Float64Var(&fuzzyThreshold,        "fuzzy-threshold", 0.92,          "Minimum similarity (0..1) of a fuzzy name match")
+	flags.StringVar(&delimiter,              "delimiter", "comma",             "Delimiter of the exported CSV files: comma, semicolon (German Excel) or tab")
+	flags.BoolVar(&options.bom,              "bom",       false,               "Start the exported CSV files with a UTF-8 byte order mark (for Excel)")
+	flags.StringVar(&options.format,         "format",    "csv",               "Format of the team files and the customers to suspend: csv or xlsx (Excel workbook)")
+	flags.BoolVar(&options.summary,          "summary",   false,               "Add a sheet with the number of cases per team and reason to the xlsx team files")
+	flags.IntVar(&batchSize,                 "batch",     0,                   "Rows an import commits at once, 0 for each file in one transaction")
+	inputFormats := inputFormatFlags(flags, "elevate", "crm", "cancelled", "failed", "payments")
+
+	flags.Parse(args)
+	options.delimiter = parseDelimiter(delimiter)
+	options.format = parseFormat(options.format)
+	formats := inputFormats()
 	
 	if dbName == "" {
-		flag.PrintDefaults()
+		flags.PrintDefaults()
 	}
-	
+
+	days := processingDays(date, dateFrom, dateTo)
+	if len(days) > 1 && files != (dailyFiles{}) {
+		log.Fatalf("File names can only be given for a single -date, a range of days uses the default file names")
+	}
+
 	fmt.Println("Received      Database Name        :", dbName)
-	fmt.Println("Received CSV-From-File Accounts    :", csvAccountsFrom)
-	fmt.Println("Received CSV-From-File CRM         :", csvCRMFrom)
-	fmt.Println("Received CSV-From-File Name        :", csvCancelledFrom)
-	fmt.Println("Received CSV-From-File Name        :", csvFailedFrom)
-	fmt.Println("Received CSV-To-Pre-Team  File Name:", csvPreTeamTo)
-	fmt.Println("Received CSV-To-Post-Team File Name:", csvPostTeamTo)
-	fmt.Println("Received CSV-To-Check     File Name:", csvOtherTeamTo)
+	fmt.Println("Received Days to process           :", strings.Join(days, ", "))
+	fmt.Println("Received Column Aliases  File Name:", columnsFrom)
+	fmt.Println("Received Routing Rules   File Name:", rulesFrom)
+	fmt.Println("Received Fuzzy Name Match Threshold:", fuzzyThreshold)
+	fmt.Println("Received Count to Suspend Payments :", countSuspend)
 	fmt.Println("***********************************************************")
 
+	loadColumnAliases(columnsFrom)
+	routing := loadRoutingRules(rulesFrom)
+
 	db := createDatabase(dbName)
-	createTableElevateAccounts(db)
-	createTableMandateEvents(db)
-	createTableCRMAccounts(db)
-	createIndexMandateEventsTimestamp(db)
-	createIndexCRMAccountsAccountNumber(db)
-	createIndexCRMAccountsName(db)
-	createIndexCRMAccountsGoCardlessId(db)
-	createIndexElevateAccountsMandateReference(db)
-	importElevateAccounts(db, csvAccountsFrom)
-	importCRMAccounts(db, csvCRMFrom)
-	importMandateEvents(db, csvCancelledFrom)
-	importMandateEvents(db, csvFailedFrom)
-	processMandateEvents(db, csvPreTeamTo, csvPostTeamTo, csvOtherTeamTo)
+	createSchema(db)
 	defer db.Close()
 
+	for _, timestamp := range days {
+		dayFiles := defaultDailyFiles(current_path, timestamp)
+		if files.csvAccountsFrom  != "" { dayFiles.csvAccountsFrom  = files.csvAccountsFrom  }
+		if files.csvCRMFrom       != "" { dayFiles.csvCRMFrom       = files.csvCRMFrom       }
+		if files.csvCancelledFrom != "" { dayFiles.csvCancelledFrom = files.csvCancelledFrom }
+		if files.csvFailedFrom    != "" { dayFiles.csvFailedFrom    = files.csvFailedFrom    }
+		if files.csvPreTeamTo     != "" { dayFiles.csvPreTeamTo     = files.csvPreTeamTo     }
+		if files.csvPostTeamTo    != "" { dayFiles.csvPostTeamTo    = files.csvPostTeamTo    }
+		if files.csvOtherTeamTo   != "" { dayFiles.csvOtherTeamTo   = files.csvOtherTeamTo   }
+		if files.csvAutoResolvedTo != "" { dayFiles.csvAutoResolvedTo = files.csvAutoResolvedTo }
+		if files.csvPaymentsFrom  != "" { dayFiles.csvPaymentsFrom  = files.csvPaymentsFrom  }
+		if files.csvSuspendTo     != "" { dayFiles.csvSuspendTo     = files.csvSuspendTo     }
+		teamFiles := routing.teamFiles(current_path, timestamp, dayFiles)
+
+		fmt.Println("***********************************************************")
+		fmt.Println("PROCESSING DAY                     :", timestamp)
+		fmt.Println("Received CSV-From-File Accounts    :", dayFiles.csvAccountsFrom)
+		fmt.Println("Received CSV-From-File CRM         :", dayFiles.csvCRMFrom)
+		fmt.Println("Received CSV-From-File Name        :", dayFiles.csvCancelledFrom)
+		fmt.Println("Received CSV-From-File Name        :", dayFiles.csvFailedFrom)
+		fmt.Println("Received CSV-From-File Payments    :", dayFiles.csvPaymentsFrom)
+		fmt.Println("Received CSV-To-Suspend   File Name:", dayFiles.csvSuspendTo)
+		for _, team := range routing.Teams {
+			fmt.Printf("Received CSV-To-Team File Name     : %s: %s\n", team.Name, teamFiles[team.Name])
+		}
+		fmt.Println("Received CSV-Auto-Resolved File Name:", teamFiles[autoResolvedTeam])
+		fmt.Println("***********************************************************")
+
+		importElevateAccounts(db, dayFiles.csvAccountsFrom, timestamp, formats["elevate"], batchSize)
+		importCRMAccounts(db, dayFiles.csvCRMFrom, timestamp, !crmPartial, formats["crm"], batchSize)
+		importMandateEvents(db, dayFiles.csvCancelledFrom, timestamp, formats["cancelled"], batchSize)
+		importMandateEvents(db, dayFiles.csvFailedFrom, timestamp, formats["failed"], batchSize)
+		processMandateEvents(db, timestamp, routing, fuzzyThreshold)
+		exportMandateCases(db, timestamp, teamFiles, "", options)
+		importPaymentEvents(db, dayFiles.csvPaymentsFrom, timestamp, formats["payments"], batchSize)
+		processPaymentsSuspended(db, timestamp, countSuspend, dayFiles.csvSuspendTo, fuzzyThreshold, options)
+	}
+
 	fmt.Println(" ")
 	fmt.Println("***********************************************************")
 	fmt.Println(" F I N I S H E D")
 	fmt.Println("***********************************************************")
 }
+
+// Print the commands of cm
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "Usage:")
+	fmt.Fprintln(os.Stderr, "  cm [run] [flags]                              import, process and export the day's files (cm run -h for the flags)")
+	fmt.Fprintln(os.Stderr, "  cm import crm|elevate|mandates|payments <file> import one file only")
+	fmt.Fprintln(os.Stderr, "  cm process [-date|-from/-to]                  find the CRM accounts and teams of the imported events")
+	fmt.Fprintln(os.Stderr, "  cm export [-date] [-team name]                export the team files of a processed day")
+	fmt.Fprintln(os.Stderr, "  cm report [-date]                             summary of a processed day and the active cases")
+	fmt.Fprintln(os.Stderr, "  cm explain <event-id>                         how an event was matched and routed")
+	fmt.Fprintln(os.Stderr, "  cm override add|remove|list|import            manual match overrides")
+	fmt.Fprintln(os.Stderr, "  cm feedback <file>                            import the case status from a team file")
+	fmt.Fprintln(os.Stderr, "  cm runs list                                  history of the imported files")
+	fmt.Fprintln(os.Stderr, "  cm db migrate [--status]                      migrate the database to the current schema version")
+}
+
+func main() {
+	var current_path = getCurrentPath()
+	var defaultDatabaseName          = filepath.Join( current_path, "cancelled-mandates-database.sqlite3"                  )
+
+	// cm without a command, or with flags only, is cm run
+	command, args := "run", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "run":
+		runCommand(args, current_path, defaultDatabaseName)
+	case "import":
+		importCommand(args, defaultDatabaseName)
+	case "process":
+		processCommand(args, defaultDatabaseName)
+	case "export":
+		exportCommand(args, current_path, defaultDatabaseName)
+	case "report":
+		reportCommand(args, defaultDatabaseName)
+	case "explain":
+		explainCommand(args, defaultDatabaseName)
+	case "override":
+		overrideCommand(args, defaultDatabaseName)
+	case "feedback":
+		feedbackCommand(args, defaultDatabaseName)
+	case "runs":
+		runsCommand(args, defaultDatabaseName)
+	case "db":
+		dbCommand(args, defaultDatabaseName)
+	default:
+		printUsage()
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// A new database with the current schema in the test's temporary directory
+func newTestDatabase(t testing.TB) *DB {
+	t.Helper()
+	db := createDatabase(filepath.Join(t.TempDir(), "cm-test.sqlite3"))
+	t.Cleanup(func() { db.Close() })
+	createSchema(db)
+	return db
+}
+
+// Insert mandate events given as column -> value into mandateEvents
+func insertTestEvents(t *testing.T, db *DB, events ...map[string]string) {
+	t.Helper()
+	for _, e := range events {
+		var columns, marks string
+		var values []interface{}
+		for column, value := range e {
+			if columns != "" {
+				columns, marks = columns+", ", marks+", "
+			}
+			columns, marks = columns+column, marks+"?"
+			values = append(values, value)
+		}
+		if _, err := db.Exec("INSERT INTO mandateEvents("+columns+") values("+marks+")", values...); err != nil {
+			t.Fatalf("insert into mandateEvents %v: %s", e, err)
+		}
+	}
+}
+
+// Import an Elevate file of a day with account A1 and the given mandate reference
+func importTestElevateFile(t *testing.T, db *DB, day string, mandate_reference string) {
+	t.Helper()
+	fileName := filepath.Join(t.TempDir(), "elevate-accounts-"+day+".csv")
+	content := "elevate_account_number,elevate_customer_name,elevate_mandate_reference\nA1,Name A1," + mandate_reference + "\n"
+	if err := os.WriteFile(fileName, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	importElevateAccounts(db, fileName, day, inputFormat{}, 0)
+}
+
+func TestImportElevateAccountsOfAnOlderDay(t *testing.T) {
+	tests := []struct {
+		name       string
+		imports    [][2]string
+		current    string
+		references string
+	}{
+		{"newer day", [][2]string{{"2026-10-14", "MDOLD"}, {"2026-10-16", "MDNEW"}},
+			"MDNEW", "MDNEW 2026-10-16 2026-10-16 -|MDOLD 2026-10-14 2026-10-14 2026-10-16"},
+		{"older day", [][2]string{{"2026-10-16", "MDNEW"}, {"2026-10-14", "MDOLD"}},
+			"MDNEW", "MDNEW 2026-10-16 2026-10-16 -"},
+		{"day between", [][2]string{{"2026-10-14", "MDOLD"}, {"2026-10-16", "MDNEW"}, {"2026-10-15", "MDOLD"}},
+			"MDNEW", "MDNEW 2026-10-16 2026-10-16 -|MDOLD 2026-10-14 2026-10-14 2026-10-16"},
+		{"same reference of an older day", [][2]string{{"2026-10-14", "MDOLD"}, {"2026-10-16", "MDOLD"}, {"2026-10-15", "MDOLD"}},
+			"MDOLD", "MDOLD 2026-10-14 2026-10-16 -"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDatabase(t)
+			for _, i := range tt.imports {
+				importTestElevateFile(t, db, i[0], i[1])
+			}
+			var current string
+			if err := db.QueryRow(`SELECT elevate_mandate_reference FROM elevateAccounts WHERE elevate_account_number = 'A1'`).Scan(&current); err != nil {
+				t.Fatal(err)
+			}
+			if current != tt.current {
+				t.Errorf("mandate reference = %q, want %q", current, tt.current)
+			}
+			row, err := db.Query(`
+				SELECT elevate_mandate_reference, first_seen_at, last_seen_at, IFNULL(NULLIF(superseded_at, ''), '-')
+				FROM elevateMandateReferences WHERE elevate_account_number = 'A1' ORDER BY elevate_mandate_reference`)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer row.Close()
+			var references []string
+			for row.Next() {
+				var reference, first_seen_at, last_seen_at, superseded_at string
+				if err := row.Scan(&reference, &first_seen_at, &last_seen_at, &superseded_at); err != nil {
+					t.Fatal(err)
+				}
+				references = append(references, strings.Join([]string{reference, first_seen_at, last_seen_at, superseded_at}, " "))
+			}
+			if got := strings.Join(references, "|"); got != tt.references {
+				t.Errorf("references = %q, want %q", got, tt.references)
+			}
+		})
+	}
+}
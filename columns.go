@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// A column the importer reads from a CSV source, together with the header
+// names it may appear under in the export of that source
+type columnSpec struct {
+	field    string
+	aliases  []string
+	required bool
+}
+
+// Alias tables per CSV source: "elevate", "crm", "mandates", "payments", "overrides" and "feedback".
+// The database field name itself is always accepted as header name.
+// Header names are compared normalized, see normalizeHeader.
+var columnAliases = map[string][]columnSpec{
+	"elevate": {
+		{field: "elevate_account_number", aliases: []string{"customer_account_number", "account_number"}, required: true},
+		{field: "elevate_customer_name", aliases: []string{"customer_name"}},
+		{field: "elevate_mandate_reference", aliases: []string{"mandate_reference"}, required: true},
+	},
+	"crm": {
+		{field: "crm_id", aliases: []string{"C0 ID"}, required: true},
+		{field: "crm_account_number", aliases: []string{"account_number", "Account Number"}, required: true},
+		{field: "crm_name", aliases: []string{"C0 Name"}, required: true},
+		{field: "crm_email", aliases: []string{"C0 Email"}},
+		{field: "crm_premise_address", aliases: []string{"premise_address", "Premise Address"}},
+		{field: "crm_stage_name", aliases: []string{"stage_name", "Stage Name"}, required: true},
+		{field: "crm_gocardless_id", aliases: []string{"C0 Go Cardless Customer ID", "gocardless_id"}, required: true},
+		{field: "crm_zen_user_id", aliases: []string{"zen_user_id", "C0 Zen User ID"}},
+	},
+	"mandates": {
+		{field: "id", required: true},
+		{field: "created_at", required: true},
+		{field: "resource_type", required: true},
+		{field: "action", required: true},
+		{field: "details_origin", aliases: []string{"details.origin"}},
+		{field: "details_cause", aliases: []string{"details.cause"}, required: true},
+		{field: "details_description", aliases: []string{"details.description"}, required: true},
+		{field: "details_scheme", aliases: []string{"details.scheme"}},
+		{field: "details_reason_code", aliases: []string{"details.reason_code"}},
+		{field: "links_previous_customer_bank_account", aliases: []string{"links.previous_customer_bank_account"}},
+		{field: "links_new_customer_bank_account", aliases: []string{"links.new_customer_bank_account"}},
+		{field: "links_parent_event", aliases: []string{"links.parent_event"}},
+		{field: "links_mandate", aliases: []string{"links.mandate"}},
+		{field: "mandates_id", aliases: []string{"mandates.id"}, required: true},
+		{field: "mandates_created_at", aliases: []string{"mandates.created_at"}},
+		{field: "mandates_reference", aliases: []string{"mandates.reference"}},
+		{field: "mandates_status", aliases: []string{"mandates.status"}},
+		{field: "mandates_scheme", aliases: []string{"mandates.scheme"}},
+		{field: "mandates_next_possible_charge_date", aliases: []string{"mandates.next_possible_charge_date"}},
+		{field: "mandates_payments_require_approval", aliases: []string{"mandates.payments_require_approval"}},
+		{field: "mandates_links_customer_bank_account", aliases: []string{"mandates.links.customer_bank_account"}},
+		{field: "mandates_links_creditor", aliases: []string{"mandates.links.creditor"}},
+		{field: "customers_id", aliases: []string{"customers.id"}, required: true},
+		{field: "customers_given_name", aliases: []string{"customers.given_name"}, required: true},
+		{field: "customers_family_name", aliases: []string{"customers.family_name"}, required: true},
+		{field: "customers_company_name", aliases: []string{"customers.company_name"}},
+		{field: "customers_metadata_leadID", aliases: []string{"customers.metadata.leadID", "customers.metadata.accountNumber"}},
+		{field: "customers_metadata_link", aliases: []string{"customers.metadata.link"}},
+		{field: "customers_metadata_xero", aliases: []string{"customers.metadata.xero"}},
+		{field: "mandates_metadata_xero", aliases: []string{"mandates.metadata.xero"}},
+	},
+	"payments": {
+		{field: "id", required: true},
+		{field: "created_at", required: true},
+		{field: "action"},
+		{field: "details_cause", aliases: []string{"details.cause"}},
+		{field: "details_description", aliases: []string{"details.description"}},
+		{field: "details_reason_code", aliases: []string{"details.reason_code"}},
+		{field: "payments_id", aliases: []string{"payments.id", "links.payment"}, required: true},
+		{field: "payments_amount", aliases: []string{"payments.amount"}},
+		{field: "payments_charge_date", aliases: []string{"payments.charge_date"}},
+		{field: "mandates_id", aliases: []string{"mandates.id", "payments.links.mandate", "links.mandate"}},
+		{field: "customers_id", aliases: []string{"customers.id"}, required: true},
+		{field: "customers_given_name", aliases: []string{"customers.given_name"}},
+		{field: "customers_family_name", aliases: []string{"customers.family_name"}},
+		{field: "customers_company_name", aliases: []string{"customers.company_name"}},
+		{field: "customers_metadata_leadID", aliases: []string{"customers.metadata.leadID", "customers.metadata.accountNumber"}},
+	},
+	"overrides": {
+		{field: "customers_id", aliases: []string{"customers.id"}},
+		{field: "mandates_id", aliases: []string{"mandates.id"}},
+		{field: "crm_id", aliases: []string{"C0 ID"}, required: true},
+		{field: "note", aliases: []string{"notes", "comment"}},
+	},
+	"feedback": {
+		{field: "id", aliases: []string{"event_id"}},
+		{field: "case_id"},
+		{field: "case_status", aliases: []string{"status"}, required: true},
+		{field: "case_owner", aliases: []string{"owner"}},
+		{field: "case_notes", aliases: []string{"notes", "comment"}},
+	},
+}
+
+// Position of each database field in the header row of one CSV file
+type columnIndex struct {
+	source   string
+	fileName string
+	header   []string
+	index    map[string]int
+}
+
+// Reduce a header name to lower case letters and digits, so that
+// "details.cause", "Details Cause" and "details_cause" are all the same
+func normalizeHeader(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// Find the position of every known column of a source in the header row.
+// A missing required column is returned as error naming the file and the column.
+func mapColumns(source string, fileName string, header []string) (columnIndex, error) {
+	specs, ok := columnAliases[source]
+	if !ok {
+		return columnIndex{}, fmt.Errorf("unknown csv source %q", source)
+	}
+
+	positions := make(map[string]int, len(header))
+	for i, name := range header {
+		key := normalizeHeader(name)
+		if _, seen := positions[key]; !seen {
+			positions[key] = i
+		}
+	}
+
+	columns := columnIndex{source: source, fileName: fileName, header: header, index: make(map[string]int, len(specs))}
+	var missing []string
+	for _, spec := range specs {
+		found := false
+		for _, name := range append([]string{spec.field}, spec.aliases...) {
+			if i, ok := positions[normalizeHeader(name)]; ok {
+				columns.index[spec.field] = i
+				found = true
+				break
+			}
+		}
+		if !found && spec.required {
+			missing = append(missing, spec.field)
+		}
+	}
+	if len(missing) > 0 {
+		return columns, fmt.Errorf("file %s is missing required %s column(s): %s", fileName, source, strings.Join(missing, ", "))
+	}
+	return columns, nil
+}
+
+// Read the header row of a CSV file and map its columns, stop on a missing required column
+func readHeader(source string, csvFileName string, recordData *csv.Reader) columnIndex {
+	header, err := recordData.Read()
+	if err != nil {
+		log.Fatalf("Missing header row(?): %s %s", csvFileName, err)
+	}
+	columns, err := mapColumns(source, csvFileName, header)
+	if err != nil {
+		log.Fatalf("Import failed: %s", err)
+	}
+	return columns
+}
+
+// Value of a field in a record, empty if the column or the value is missing
+func (c columnIndex) get(record []string, field string) string {
+	i, ok := c.index[field]
+	if !ok || i >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[i])
+}
+
+// Extend the built-in alias tables from a JSON file of the form
+//
+//	{"crm": {"crm_gocardless_id": ["GoCardless Customer"]}}
+func loadColumnAliases(fileName string) {
+	if fileName == "" {
+		return
+	}
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		log.Fatalf("Cannot read column alias file: %s %s", fileName, err)
+	}
+	if err = addColumnAliases(data); err != nil {
+		log.Fatalf("Invalid column alias file: %s %s", fileName, err)
+	}
+}
+
+// Add the aliases of a column alias file to the built-in alias tables. An unknown source
+// or field is returned as error, before any alias is added.
+func addColumnAliases(data []byte) error {
+	var extra map[string]map[string][]string
+	if err := json.Unmarshal(data, &extra); err != nil {
+		return err
+	}
+	for source, fields := range extra {
+		specs, ok := columnAliases[source]
+		if !ok {
+			return fmt.Errorf("unknown source %q", source)
+		}
+		for field := range fields {
+			known := false
+			for _, spec := range specs {
+				known = known || spec.field == field
+			}
+			if !known {
+				return fmt.Errorf("unknown %s field %q", source, field)
+			}
+		}
+	}
+	for source, fields := range extra {
+		specs := columnAliases[source]
+		for i := range specs {
+			if aliases, ok := fields[specs[i].field]; ok {
+				specs[i].aliases = append(aliases, specs[i].aliases...)
+			}
+		}
+	}
+	return nil
+}
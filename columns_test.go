@@ -0,0 +1,132 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeHeader(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"details.cause", "detailscause"},
+		{"Details Cause", "detailscause"},
+		{"details_cause", "detailscause"},
+		{" C0 Go-Cardless Customer ID ", "c0gocardlesscustomerid"},
+		{"customers.metadata.leadID", "customersmetadataleadid"},
+	}
+	for _, tt := range tests {
+		if got := normalizeHeader(tt.name); got != tt.want {
+			t.Errorf("normalizeHeader(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMapColumns(t *testing.T) {
+	crm := []string{"C0 ID", "Account Number", "C0 Name", "Stage Name", "C0 Go Cardless Customer ID"}
+	tests := []struct {
+		name   string
+		source string
+		header []string
+		want   map[string]int
+		err    string
+	}{
+		{"field names", "elevate", []string{"elevate_account_number", "elevate_customer_name", "elevate_mandate_reference"},
+			map[string]int{"elevate_account_number": 0, "elevate_customer_name": 1, "elevate_mandate_reference": 2}, ""},
+		{"reordered aliases", "elevate", []string{"Mandate Reference", "customer_name", "Customer Account Number"},
+			map[string]int{"elevate_account_number": 2, "elevate_customer_name": 1, "elevate_mandate_reference": 0}, ""},
+		{"field name before alias", "elevate", []string{"account_number", "mandate_reference", "elevate_account_number"},
+			map[string]int{"elevate_account_number": 2, "elevate_mandate_reference": 1}, ""},
+		{"first of duplicate headers", "elevate", []string{"account_number", "Account Number", "mandate_reference", "mandate.reference"},
+			map[string]int{"elevate_account_number": 0, "elevate_mandate_reference": 2}, ""},
+		{"crm export", "crm", append([]string{"Extra Column"}, crm...),
+			map[string]int{"crm_id": 1, "crm_account_number": 2, "crm_name": 3, "crm_stage_name": 4, "crm_gocardless_id": 5}, ""},
+		{"gocardless export", "payments", []string{"customers.id", "links.payment", "id", "created_at", "payments.charge_date"},
+			map[string]int{"customers_id": 0, "payments_id": 1, "id": 2, "created_at": 3, "payments_charge_date": 4}, ""},
+		{"missing required column", "crm", crm[:4], nil,
+			"file crm.csv is missing required crm column(s): crm_gocardless_id"},
+		{"missing required columns", "elevate", []string{"customer_name"}, nil,
+			"file elevate.csv is missing required elevate column(s): elevate_account_number, elevate_mandate_reference"},
+		{"unknown source", "bank", []string{"id"}, nil, `unknown csv source "bank"`},
+	}
+	for _, tt := range tests {
+		columns, err := mapColumns(tt.source, tt.source+".csv", tt.header)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if len(columns.index) != len(tt.want) {
+			t.Errorf("%s: mapped %v, want %v", tt.name, columns.index, tt.want)
+		}
+		for field, i := range tt.want {
+			if got, ok := columns.index[field]; !ok || got != i {
+				t.Errorf("%s: %s is column %d (%v), want %d", tt.name, field, got, ok, i)
+			}
+		}
+	}
+}
+
+func TestGetColumn(t *testing.T) {
+	columns, err := mapColumns("elevate", "elevate.csv", []string{"mandate_reference", "account_number", "customer_name"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := []string{" MD1 ", "A1"}
+	if got := columns.get(record, "elevate_account_number"); got != "A1" {
+		t.Errorf("account number = %q, want A1", got)
+	}
+	if got := columns.get(record, "elevate_mandate_reference"); got != "MD1" {
+		t.Errorf("mandate reference = %q, want MD1 without spaces", got)
+	}
+	if got := columns.get(record, "elevate_customer_name"); got != "" {
+		t.Errorf("customer name of a short record = %q, want empty", got)
+	}
+}
+
+func TestAddColumnAliases(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		err  string
+	}{
+		{"alias", `{"crm": {"crm_gocardless_id": ["GoCardless Customer"]}}`, ""},
+		{"unknown source", `{"bank": {"id": ["Bank ID"]}}`, `unknown source "bank"`},
+		{"unknown field", `{"crm": {"crm_gocardless_id": ["GoCardless Customer"], "crm_phone": ["Phone"]}}`, `unknown crm field "crm_phone"`},
+		{"not json", `{"crm": ["GoCardless Customer"]}`, "cannot unmarshal"},
+	}
+	header := []string{"C0 ID", "Account Number", "C0 Name", "Stage Name", "GoCardless Customer"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saved := make([]columnSpec, len(columnAliases["crm"]))
+			copy(saved, columnAliases["crm"])
+			defer func() { columnAliases["crm"] = saved }()
+
+			err := addColumnAliases([]byte(tt.json))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("error %v, want %q", err, tt.err)
+				}
+				if _, err := mapColumns("crm", "crm.csv", header); err == nil {
+					t.Error("the aliases of an invalid file were added")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			columns, err := mapColumns("crm", "crm.csv", header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if columns.index["crm_gocardless_id"] != 4 {
+				t.Errorf("crm_gocardless_id is column %d, want 4", columns.index["crm_gocardless_id"])
+			}
+		})
+	}
+}
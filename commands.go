@@ -0,0 +1,210 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// cm import crm|elevate|mandates|payments <file.csv>: import one file without processing it,
+// e.g. a CRM refresh during the day
+func importCommand(args []string, defaultDatabaseName string) {
+	var dbName, date, columnsFrom string
+	var partial bool
+	var batchSize int
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	flags.StringVar(&dbName, "db", defaultDatabaseName, "Sqlite database to import to")
+	flags.StringVar(&date, "date", "", "Day the CRM or Elevate accounts, mandate events or payments are imported for as YYYY-MM-DD (default today)")
+	flags.StringVar(&columnsFrom, "columns", "", "JSON file with additional header names per csv column")
+	flags.BoolVar(&partial, "partial", false, "The CRM file holds only some accounts: don't mark the accounts missing from it as deleted")
+	flags.IntVar(&batchSize, "batch", 0, "Rows the import commits at once, 0 for the whole file in one transaction")
+	inputFormats := inputFormatFlags(flags, "")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage:")
+		fmt.Fprintln(flags.Output(), "  cm import crm      [-db file] [-date YYYY-MM-DD] [-partial] <file.csv>   CRM snapshot of the day")
+		fmt.Fprintln(flags.Output(), "  cm import elevate  [-db file] [-date YYYY-MM-DD] <file.csv>")
+		fmt.Fprintln(flags.Output(), "  cm import mandates [-db file] [-date YYYY-MM-DD] <file.csv>   cancelled or failed mandates")
+		fmt.Fprintln(flags.Output(), "  cm import payments [-db file] [-date YYYY-MM-DD] <file.csv>   failed payments")
+		flags.PrintDefaults()
+	}
+	if len(args) == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	source := args[0]
+	flags.Parse(args[1:])
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	fileName := flags.Arg(0)
+	if _, err := os.Stat(fileName); err != nil {
+		log.Fatalf("Cannot open file: %s", err)
+	}
+	format := inputFormats()[""]
+	timestamp := processingDays(date, "", "")[0]
+
+	loadColumnAliases(columnsFrom)
+	db := createDatabase(dbName)
+	defer db.Close()
+	createSchema(db)
+
+	switch source {
+	case "crm":
+		importCRMAccounts(db, fileName, timestamp, !partial, format, batchSize)
+	case "elevate":
+		importElevateAccounts(db, fileName, timestamp, format, batchSize)
+	case "mandates":
+		importMandateEvents(db, fileName, timestamp, format, batchSize)
+	case "payments":
+		importPaymentEvents(db, fileName, timestamp, format, batchSize)
+	default:
+		flags.Usage()
+		os.Exit(2)
+	}
+}
+
+// cm process: find the CRM accounts and teams of the events imported on one or more days,
+// without exporting the team files
+func processCommand(args []string, defaultDatabaseName string) {
+	var dbName, date, dateFrom, dateTo, rulesFrom string
+	var fuzzyThreshold float64
+	flags := flag.NewFlagSet("process", flag.ExitOnError)
+	flags.StringVar(&dbName, "db", defaultDatabaseName, "Sqlite database to use")
+	flags.StringVar(&date, "date", "", "Day to process as YYYY-MM-DD (default today)")
+	flags.StringVar(&dateFrom, "from", "", "First day to process as YYYY-MM-DD, for a range of days")
+	flags.StringVar(&dateTo, "to", "", "Last day to process as YYYY-MM-DD, for a range of days (default today)")
+	flags.StringVar(&rulesFrom, "rules", "", "JSON file with teams and routing rules (default: built-in rules)")
+	flags.Float64Var(&fuzzyThreshold, "fuzzy-threshold", 0.92, "Minimum similarity (0..1) of a fuzzy name match")
+	flags.Parse(args)
+
+	days := processingDays(date, dateFrom, dateTo)
+	routing := loadRoutingRules(rulesFrom)
+	db := createDatabase(dbName)
+	defer db.Close()
+	createSchema(db)
+
+	for _, timestamp := range days {
+		fmt.Println("PROCESSING DAY                     :", timestamp)
+		processMandateEvents(db, timestamp, routing, fuzzyThreshold)
+	}
+	fmt.Println("Export the team files with: cm export -date", days[len(days)-1])
+}
+
+// cm export: export the team files of a processed day again, of all teams or of one team
+func exportCommand(args []string, current_path string, defaultDatabaseName string) {
+	var dbName, date, team, rulesFrom, delimiter string
+	var options exportOptions
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	flags.StringVar(&dbName, "db", defaultDatabaseName, "Sqlite database to use")
+	flags.StringVar(&date, "date", "", "Processed day to export as YYYY-MM-DD (default today)")
+	flags.StringVar(&team, "team", "", "Name of the team to export the file of, with the teams sharing it (default all teams)")
+	flags.StringVar(&rulesFrom, "rules", "", "JSON file with teams and routing rules (default: built-in rules)")
+	flags.StringVar(&delimiter, "delimiter", "comma", "Delimiter of the exported CSV files: comma, semicolon (German Excel) or tab")
+	flags.BoolVar(&options.bom, "bom", false, "Start the exported CSV files with a UTF-8 byte order mark (for Excel)")
+	flags.StringVar(&options.format, "format", "csv", "Format of the team files: csv or xlsx (Excel workbook)")
+	flags.BoolVar(&options.summary, "summary", false, "Add a sheet with the number of cases per team and reason to the xlsx team files")
+	flags.Parse(args)
+	options.delimiter = parseDelimiter(delimiter)
+	options.format = parseFormat(options.format)
+
+	timestamp := processingDays(date, "", "")[0]
+	routing := loadRoutingRules(rulesFrom)
+	teamFiles := routing.teamFiles(current_path, timestamp, defaultDailyFiles(current_path, timestamp))
+	if _, ok := teamFiles[team]; team != "" && !ok {
+		var names []string
+		for name := range teamFiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		log.Fatalf("Unknown team %q, expected one of: %s", team, strings.Join(names, ", "))
+	}
+
+	db := createDatabase(dbName)
+	defer db.Close()
+	createSchema(db)
+
+	var count int
+	db.QueryRow(`SELECT count(*) FROM caseRoutings WHERE timestamp = ?`, timestamp).Scan(&count)
+	if count == 0 {
+		fmt.Println("WARNING: No cases were processed on", timestamp, "- run cm process -date", timestamp, "first")
+	}
+	exportMandateCases(db, timestamp, teamFiles, team, options)
+}
+
+// Print counts sorted by their key, with a title
+func printCounts(title string, counts map[string]int) {
+	fmt.Println(title)
+	var keys []string
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if key == "" {
+			fmt.Printf("  %-40s %6d\n", "(none)", counts[key])
+		} else {
+			fmt.Printf("  %-40s %6d\n", key, counts[key])
+		}
+	}
+}
+
+// cm report: the cases of a processed day per team, case type, reason and match method,
+// and the active cases of all days per status. cm report stages: the CRM stage changes.
+func reportCommand(args []string, defaultDatabaseName string) {
+	if len(args) > 0 && args[0] == "stages" {
+		stageChangesCommand(args[1:], defaultDatabaseName)
+		return
+	}
+	var dbName, date string
+	flags := flag.NewFlagSet("report", flag.ExitOnError)
+	flags.StringVar(&dbName, "db", defaultDatabaseName, "Sqlite database to use")
+	flags.StringVar(&date, "date", "", "Processed day to report as YYYY-MM-DD (default today)")
+	flags.Parse(args)
+
+	timestamp := processingDays(date, "", "")[0]
+	db := openDatabaseReadOnly(dbName)
+	defer db.Close()
+
+	teams := make(map[string]int)
+	caseTypes := make(map[string]int)
+	categories := make(map[string]int)
+	methods := make(map[string]int)
+	routings := loadCaseRoutings(db, timestamp, "")
+	for _, r := range routings {
+		teams[r.target_team]++
+		caseTypes[r.case_type]++
+		categories[classifyReason(&r.event).category]++
+		methods[matchMethodName(r.match.method)]++
+	}
+
+	fmt.Println("***********************************************************")
+	fmt.Println("REPORT", timestamp, "-", len(routings), "cases")
+	fmt.Println("***********************************************************")
+	printCounts("Cases per team:", teams)
+	printCounts("Cases per case type:", caseTypes)
+	printCounts("Cases per reason category:", categories)
+	printCounts("Cases per match method:", methods)
+
+	SQLGetActiveCases := `
+		SELECT status, count(*), min(opened_at)
+		FROM cases
+		WHERE status IN (` + activeCaseStatuses + `)
+		GROUP BY status
+		ORDER BY status`
+	row, err := db.Query(SQLGetActiveCases)
+	if err != nil {
+		log.Fatalf("Cannot read cases: %s", err)
+	}
+	defer row.Close()
+	fmt.Println("Active cases of all days per status:")
+	for row.Next() {
+		var status, oldest string
+		var count int
+		row.Scan(&status, &count, &oldest)
+		fmt.Printf("  %-40s %6d   oldest opened at %s\n", status, count, oldest)
+	}
+}
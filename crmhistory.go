@@ -0,0 +1,281 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+)
+
+// The queries keeping the versions of the CRM accounts in crmAccountHistory during a CRM import,
+// with the accounts skipped as they have a version after the day of the import
+type crmHistory struct {
+	current *CMD
+	since   *CMD
+	end     *CMD
+	insert  *CMD
+	update  *CMD
+	skipped int
+	newest  string
+}
+
+// Prepare the queries of crmAccountHistory in the transaction of a CRM import
+func newCRMHistory(tx *importTx) *crmHistory {
+	return &crmHistory{
+		current: tx.prepare("select from crmAccountHistory", `
+			SELECT `+crmAccountColumns+`, valid_from
+			FROM crmAccountHistory
+			WHERE crm_id = ? AND valid_to = ''`),
+		since: tx.prepare("select from crmAccountHistory", `
+			SELECT valid_from
+			FROM crmAccountHistory
+			WHERE crm_id = ? AND valid_to = ''`),
+		end: tx.prepare("update crmAccountHistory", `
+			UPDATE crmAccountHistory
+			SET valid_to = ?
+			WHERE crm_id = ? AND valid_to = ''`),
+		insert: tx.prepare("insert into crmAccountHistory", `
+			INSERT INTO crmAccountHistory(`+crmAccountColumns+`, valid_from, valid_to)
+			values(?, ?, ?, ?, ?, ?, ?, ?, ?, '')`),
+		update: tx.prepare("update crmAccountHistory", `
+			UPDATE crmAccountHistory
+			SET crm_account_number = ?, crm_name = ?, crm_email = ?, crm_premise_address = ?,
+			    crm_stage_name = ?, crm_gocardless_id = ?, crm_zen_user_id = ?
+			WHERE crm_id = ? AND valid_from = ?`),
+	}
+}
+
+// Whether the account has a version after day (YYYY-MM-DD) already, so the import of that day
+// is older than what cm knows of the account and neither changes the account nor its history
+func (h *crmHistory) outdated(crm_id string, day string) bool {
+	var valid_from string
+	err := h.since.QueryRow(crm_id).Scan(&valid_from)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false
+	}
+	if err != nil {
+		log.Fatalf("Cannot read crmAccountHistory %s: %s", crm_id, err)
+	}
+	if day >= valid_from {
+		return false
+	}
+	h.skipped++
+	if valid_from > h.newest {
+		h.newest = valid_from
+	}
+	return true
+}
+
+// Report the accounts skipped by an import of day once for the file
+func (h *crmHistory) warnOutdated(fileName string, day string) {
+	if h.skipped > 0 {
+		fmt.Printf("WARNING: %d accounts of %s were not imported, as they have versions after %s already (up to %s)\n",
+			h.skipped, fileName, day, h.newest)
+	}
+}
+
+// Record an account of a CRM import of day (YYYY-MM-DD): a new account gets its first version,
+// a changed account a new version from that day on. A second import on the same day changes
+// that day's version. An import of a day before the current version is not recorded.
+func (h *crmHistory) record(account crmAccount, day string) error {
+	var current crmAccount
+	var valid_from string
+	err := h.current.QueryRow(account.crm_id).Scan(&current.crm_account_number, &current.crm_id, &current.crm_name, &current.crm_email,
+		&current.crm_premise_address, &current.crm_stage_name, &current.crm_gocardless_id, &current.crm_zen_user_id, &valid_from)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		return err
+	case current == account:
+		return nil
+	case day < valid_from:
+		return fmt.Errorf("the account has a version from %s already", valid_from)
+	case day == valid_from:
+		_, err = h.update.Exec(account.crm_account_number, account.crm_name, account.crm_email, account.crm_premise_address,
+			account.crm_stage_name, account.crm_gocardless_id, account.crm_zen_user_id, account.crm_id, day)
+		return err
+	default:
+		if _, err = h.end.Exec(day, account.crm_id); err != nil {
+			return err
+		}
+	}
+	_, err = h.insert.Exec(account.crm_account_number, account.crm_id, account.crm_name, account.crm_email,
+		account.crm_premise_address, account.crm_stage_name, account.crm_gocardless_id, account.crm_zen_user_id, day)
+	return err
+}
+
+// Query of the CRM stage in effect on a day, prepared by newMatcher: the version valid on
+// that day, or the first version, if the account was imported after that day
+const SQLGetCRMStageAt = `
+	SELECT crm_stage_name
+	FROM crmAccountHistory
+	WHERE crm_id = ?1
+	ORDER BY valid_from <= ?2 DESC,
+	         CASE WHEN valid_from <= ?2 THEN valid_from END DESC,
+	         valid_from
+	LIMIT 1`
+
+// The CRM stage an account had on the day of created_at (an RFC 3339 time of GoCardless),
+// its current stage if it has no history
+func (m *matcher) stageAt(account crmAccount, created_at string) string {
+	if account.crm_id == "" {
+		return account.crm_stage_name
+	}
+	day := created_at
+	if len(day) > 10 {
+		day = day[:10]
+	}
+	var stage string
+	err := m.stages.QueryRow(account.crm_id, day).Scan(&stage)
+	if errors.Is(err, sql.ErrNoRows) {
+		return account.crm_stage_name
+	}
+	if err != nil {
+		log.Fatalf("Cannot read the CRM stage of %s on %s: %s", account.crm_id, day, err)
+	}
+	return stage
+}
+
+// cm report stages: the CRM accounts whose stage changed between two CRM imports
+func stageChangesCommand(args []string, defaultDatabaseName string) {
+	var dbName, dateFrom, dateTo string
+	flags := flag.NewFlagSet("report stages", flag.ExitOnError)
+	flags.StringVar(&dbName, "db", defaultDatabaseName, "Sqlite database to use")
+	flags.StringVar(&dateFrom, "from", "", "First day of a change as YYYY-MM-DD (default: the first import)")
+	flags.StringVar(&dateTo, "to", "", "Last day of a change as YYYY-MM-DD (default: the last import)")
+	flags.Parse(args)
+
+	db := openDatabaseReadOnly(dbName)
+	defer db.Close()
+
+	SQLGetStageChanges := `
+		SELECT later.valid_from, later.crm_id, later.crm_account_number, later.crm_name,
+		    earlier.crm_stage_name, later.crm_stage_name
+		FROM crmAccountHistory later
+		INNER JOIN crmAccountHistory earlier
+		ON earlier.crm_id = later.crm_id AND earlier.valid_to = later.valid_from AND earlier.valid_to != ''
+		WHERE earlier.crm_stage_name != later.crm_stage_name
+		  AND (?1 = '' OR later.valid_from >= ?1)
+		  AND (?2 = '' OR later.valid_from <= ?2)
+		ORDER BY later.valid_from, later.crm_account_number, later.crm_id`
+	row, err := db.Query(SQLGetStageChanges, dateFrom, dateTo)
+	if err != nil {
+		log.Fatalf("Cannot read crmAccountHistory: %s", err)
+	}
+	defer row.Close()
+
+	fmt.Println("***********************************************************")
+	fmt.Println("CRM STAGE CHANGES")
+	fmt.Println("***********************************************************")
+	fmt.Printf("%-10s  %-12s  %-12s  %-30s  %s\n", "changed on", "crm_id", "account", "name", "stage")
+	count := 0
+	for row.Next() {
+		var changed_on, crm_id, crm_account_number, crm_name, stage_before, stage_after string
+		if err := row.Scan(&changed_on, &crm_id, &crm_account_number, &crm_name, &stage_before, &stage_after); err != nil {
+			log.Fatalf("Cannot read crmAccountHistory: %s", err)
+		}
+		fmt.Printf("%-10s  %-12s  %-12s  %-30s  %s -> %s\n", changed_on, crm_id, crm_account_number, crm_name, stage_before, stage_after)
+		count++
+	}
+	if err = row.Err(); err != nil {
+		log.Fatalf("Cannot read crmAccountHistory: %s", err)
+	}
+	fmt.Println(count, "stage changes")
+}
+
+// A CRM import with the crm_ids it contained, see table crmImports
+type crmImport struct {
+	tx        *importTx
+	import_id int64
+	day       string
+	full      bool
+	seen      *CMD
+	count     int
+}
+
+// Record the start of the import of a CRM file for day (YYYY-MM-DD) in its transaction. A full
+// import holds all accounts of the CRM, a partial import e.g. the accounts changed during the day.
+func newCRMImport(tx *importTx, fileName string, day string, full bool) *crmImport {
+	result, err := tx.Exec(`INSERT INTO crmImports(file_name, imported_for, imported_at, full_import) values(?, ?, ?, ?)`,
+		fileName, day, time.Now().Format("2006-01-02 15:04:05"), full)
+	if err != nil {
+		log.Fatalf("Insert into table crmImports failed for %s: %s", fileName, err)
+	}
+	ci := &crmImport{tx: tx, day: day, full: full}
+	if ci.import_id, err = result.LastInsertId(); err != nil {
+		log.Fatalf("Insert into table crmImports failed for %s: %s", fileName, err)
+	}
+	ci.seen = tx.prepare("insert into crmImportAccounts", `INSERT OR IGNORE INTO crmImportAccounts(import_id, crm_id) values(?, ?)`)
+	return ci
+}
+
+// Record a crm_id of the file
+func (ci *crmImport) see(crm_id string) {
+	if crm_id == "" {
+		return
+	}
+	if _, err := ci.seen.Exec(ci.import_id, crm_id); err != nil {
+		fmt.Println("ERROR:   Insert into table crmImportAccounts failed for id =", crm_id, err)
+		return
+	}
+	ci.count++
+}
+
+// End the import. After a full import the accounts missing from it are marked as deleted,
+// unless the file had no accounts at all, which is rather a broken export than an empty CRM,
+// or rejected rows, which may hold the accounts missing, or a later full import was imported
+// already. An account is never marked as deleted on a day before its first version.
+func (ci *crmImport) finish(rejected int) {
+	if _, err := ci.tx.Exec(`UPDATE crmImports SET account_count = ? WHERE import_id = ?`, ci.count, ci.import_id); err != nil {
+		log.Fatalf("Update of table crmImports failed: %s", err)
+	}
+	if !ci.full {
+		return
+	}
+	if ci.count == 0 {
+		fmt.Println("WARNING: The CRM file has no accounts, no account is marked as deleted")
+		return
+	}
+	if rejected > 0 {
+		fmt.Println("WARNING: The CRM file has", rejected, "rejected rows, no account is marked as deleted")
+		return
+	}
+	var latest string
+	err := ci.tx.QueryRow(`SELECT IFNULL(MAX(imported_for), '') FROM crmImports WHERE full_import = 1 AND import_id != ?`,
+		ci.import_id).Scan(&latest)
+	if err != nil {
+		log.Fatalf("Cannot read crmImports: %s", err)
+	}
+	if ci.day < latest {
+		fmt.Println("WARNING: A full CRM import of", latest, "was imported already, no account is marked as deleted on", ci.day)
+		return
+	}
+	SQLMarkDeleted := `
+		UPDATE crmAccounts
+		SET crm_deleted_at = ?1
+		WHERE IFNULL(crm_deleted_at, '') = ''
+		  AND crm_id NOT IN (SELECT crm_id FROM crmImportAccounts WHERE import_id = ?2)
+		  AND IFNULL((SELECT MIN(valid_from) FROM crmAccountHistory h WHERE h.crm_id = crmAccounts.crm_id), '') <= ?1`
+	result, err := ci.tx.Exec(SQLMarkDeleted, ci.day, ci.import_id)
+	if err != nil {
+		log.Fatalf("Update of table crmAccounts failed: %s", err)
+	}
+	if deleted, _ := result.RowsAffected(); deleted > 0 {
+		fmt.Println("SUCCESS: Marked", deleted, "CRM accounts as deleted, which are missing from the full CRM import")
+	}
+}
+
+// The day an account was marked as deleted in the CRM, "" if it is still in the CRM
+func (m *matcher) deletedAt(account crmAccount) string {
+	if account.crm_id == "" {
+		return ""
+	}
+	var deleted_at string
+	err := m.deleted.QueryRow(account.crm_id).Scan(&deleted_at)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Fatalf("Cannot read crmAccounts %s: %s", account.crm_id, err)
+	}
+	return deleted_at
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Write a CRM file with the given rows of crm_id, account number and stage
+func writeTestCRMFile(t *testing.T, name string, rows ...[3]string) string {
+	t.Helper()
+	content := "C0 ID,Account Number,C0 Name,Stage Name,C0 Go Cardless Customer ID\n"
+	for _, r := range rows {
+		content += r[0] + "," + r[1] + ",Name " + r[1] + "," + r[2] + ",CU" + r[1] + "\n"
+	}
+	fileName := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(fileName, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+// The current stage and deletion day of the CRM accounts
+func testCRMAccounts(t *testing.T, db *DB) map[string][2]string {
+	t.Helper()
+	row, err := db.Query(`SELECT crm_id, crm_stage_name, IFNULL(crm_deleted_at, '') FROM crmAccounts`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer row.Close()
+	accounts := make(map[string][2]string)
+	for row.Next() {
+		var crm_id, stage, deleted_at string
+		if err := row.Scan(&crm_id, &stage, &deleted_at); err != nil {
+			t.Fatal(err)
+		}
+		accounts[crm_id] = [2]string{stage, deleted_at}
+	}
+	return accounts
+}
+
+func TestImportCRMAccountsOfAnOlderDay(t *testing.T) {
+	db := newTestDatabase(t)
+	importCRMAccounts(db, writeTestCRMFile(t, "crm-16.csv", [3]string{"C1", "A1", "ACTIVE"}), "2026-10-16", true, inputFormat{}, 0)
+	importCRMAccounts(db, writeTestCRMFile(t, "crm-14.csv", [3]string{"C1", "A1", "SOLD"}), "2026-10-14", true, inputFormat{}, 0)
+
+	if got := testCRMAccounts(t, db)["C1"][0]; got != "ACTIVE" {
+		t.Errorf("stage of C1 after importing an older file = %q, want ACTIVE", got)
+	}
+	var versions int
+	db.QueryRow(`SELECT count(*) FROM crmAccountHistory WHERE crm_id = 'C1'`).Scan(&versions)
+	if versions != 1 {
+		t.Errorf("C1 has %d versions, want 1", versions)
+	}
+}
+
+// A CRM file of a day to import, full or partial
+type testCRMImport struct {
+	day  string
+	full bool
+	rows [][3]string
+}
+
+func TestMarkDeletedCRMAccounts(t *testing.T) {
+	c1, c2, c5 := [3]string{"C1", "A1", "ACTIVE"}, [3]string{"C2", "A2", "ACTIVE"}, [3]string{"C5", "A5", "ACTIVE"}
+	tests := []struct {
+		name    string
+		imports []testCRMImport
+		want    map[string]string
+	}{
+		{"missing from a later full import", []testCRMImport{
+			{"2026-10-14", true, [][3]string{c1, c2}},
+			{"2026-10-15", true, [][3]string{c1}},
+		}, map[string]string{"C1": "", "C2": "2026-10-15"}},
+		{"missing from a partial import", []testCRMImport{
+			{"2026-10-14", true, [][3]string{c1, c2}},
+			{"2026-10-15", false, [][3]string{c1}},
+		}, map[string]string{"C1": "", "C2": ""}},
+		{"back again in a later full import", []testCRMImport{
+			{"2026-10-14", true, [][3]string{c1, c2}},
+			{"2026-10-15", true, [][3]string{c1}},
+			{"2026-10-16", true, [][3]string{c1, c2}},
+		}, map[string]string{"C1": "", "C2": ""}},
+		{"missing from a full import older than the latest", []testCRMImport{
+			{"2026-10-14", true, [][3]string{c1}},
+			{"2026-10-16", true, [][3]string{c1, c5}},
+			{"2026-10-14", true, [][3]string{c1}},
+		}, map[string]string{"C1": "", "C5": ""}},
+		{"missing from a full import before its first version", []testCRMImport{
+			{"2026-10-16", false, [][3]string{c5}},
+			{"2026-10-14", true, [][3]string{c1}},
+		}, map[string]string{"C1": "", "C5": ""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDatabase(t)
+			for i, imp := range tt.imports {
+				fileName := writeTestCRMFile(t, "crm-"+imp.day+"-"+string(rune('a'+i))+".csv", imp.rows...)
+				importCRMAccounts(db, fileName, imp.day, imp.full, inputFormat{}, 0)
+			}
+			accounts := testCRMAccounts(t, db)
+			for crm_id, want := range tt.want {
+				if got := accounts[crm_id][1]; got != want {
+					t.Errorf("crm_deleted_at of %s = %q, want %q", crm_id, got, want)
+				}
+			}
+		})
+	}
+}
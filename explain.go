@@ -0,0 +1,176 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// cm explain <event-id|mandate-id|customer-id>: why the events of a mandate or customer
+// landed in the file they did: the stored match and routing, and the match methods and
+// routing rules run again, read-only, on the current data
+func explainCommand(args []string, defaultDatabaseName string) {
+	var dbName, rulesFrom string
+	var fuzzyThreshold float64
+	flags := flag.NewFlagSet("explain", flag.ExitOnError)
+	flags.StringVar(&dbName, "db", defaultDatabaseName, "Sqlite database to use")
+	flags.StringVar(&rulesFrom, "rules", "", "JSON file with teams and routing rules (default: built-in rules)")
+	flags.Float64Var(&fuzzyThreshold, "fuzzy-threshold", 0.92, "Minimum similarity (0..1) of a fuzzy name match")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage:")
+		fmt.Fprintln(flags.Output(), "  cm explain [-db file] [-rules file] <event-id | mandate-id | customer-id>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	routing := loadRoutingRules(rulesFrom)
+	db := openDatabaseReadOnly(dbName)
+	defer db.Close()
+
+	ids := explainEventIds(db, flags.Arg(0))
+	if len(ids) == 0 {
+		log.Fatalf("No mandate event with id, mandates_id or customers_id %s", flags.Arg(0))
+	}
+	matcher := newMatcher(db, fuzzyThreshold)
+	defer matcher.close()
+	for _, id := range ids {
+		explainEvent(db, id)
+		explainMatchAndRouting(db, matcher, routing, id)
+	}
+}
+
+// Ids of the events with the id, mandates_id or customers_id given, oldest first
+func explainEventIds(db *DB, id string) []string {
+	row, err := db.Query(`
+		SELECT id
+		FROM mandateEvents
+		WHERE id = ?1 OR mandates_id = ?1 OR customers_id = ?1
+		ORDER BY created_at, id`, id)
+	if err != nil {
+		log.Fatalf("Cannot read mandateEvents: %s", err)
+	}
+	defer row.Close()
+	var ids []string
+	for row.Next() {
+		var eventId string
+		row.Scan(&eventId)
+		ids = append(ids, eventId)
+	}
+	return ids
+}
+
+// Print an event with its case, its stored match result and the teams it was routed to
+func explainEvent(db *DB, id string) {
+	e, err := loadMandateEvent(db, id)
+	if err != nil {
+		log.Fatalf("Cannot find event %s: %s", id, err)
+	}
+	reason := classifyReason(&e)
+	fmt.Println("***********************************************************")
+	fmt.Println("Event:   ", e.id, e.action, e.details_cause, e.details_reason_code, "created at", e.created_at, "imported at", e.imported_at)
+	fmt.Println("Customer:", e.customers_id, e.customers_name, e.customers_company_name, "leadID:", e.customers_metadata_leadID)
+	fmt.Println("Mandate: ", e.mandates_id, e.mandates_reference, e.mandates_status)
+	fmt.Println("Reason:  ", reason.category+",", reason.severity+":", reason.explanation)
+
+	var caseId int64
+	var opened_at, status, owner, notes string
+	SQLGetCase := `
+		SELECT cases.case_id, opened_at, status, owner, notes
+		FROM caseEvents
+		INNER JOIN cases USING (case_id)
+		WHERE event_id = ?`
+	if err = db.QueryRow(SQLGetCase, e.id).Scan(&caseId, &opened_at, &status, &owner, &notes); err == nil {
+		fmt.Println("Case:    ", caseId, "opened at", opened_at, "status:", status, "owner:", owner, "notes:", notes)
+	} else {
+		fmt.Println("Case:     none, the event was not processed yet")
+	}
+
+	var method int
+	var key, crm_id, candidates, note, matched_at string
+	var score float64
+	var ambiguous bool
+	SQLGetMatch := `
+		SELECT match_method, match_key, crm_id, IFNULL(match_score, 0), IFNULL(match_ambiguous, 0), IFNULL(match_candidates, ''), IFNULL(match_note, ''), matched_at
+		FROM matchResults
+		WHERE event_id = ?`
+	if err = db.QueryRow(SQLGetMatch, e.id).Scan(&method, &key, &crm_id, &score, &ambiguous, &candidates, &note, &matched_at); err == nil {
+		fmt.Printf("Stored:   %s with key %q, crm_id %s, score %.3f, matched at %s\n", matchMethodName(method), key, crm_id, score, matched_at)
+		if note != "" {
+			fmt.Println("          note:", note)
+		}
+		if ambiguous {
+			fmt.Println("          ambiguous, candidates:", candidates)
+		}
+	} else {
+		fmt.Println("Stored:   no match result")
+	}
+
+	row, err := db.Query(`SELECT timestamp, target_team, routing_rule, IFNULL(crm_stage_at_event, ''), auto_resolved_reason FROM caseRoutings WHERE event_id = ? ORDER BY timestamp`, e.id)
+	if err != nil {
+		log.Fatalf("Cannot read caseRoutings: %s", err)
+	}
+	defer row.Close()
+	for row.Next() {
+		var timestamp, target_team, routing_rule, crm_stage_at_event, auto_resolved_reason string
+		row.Scan(&timestamp, &target_team, &routing_rule, &crm_stage_at_event, &auto_resolved_reason)
+		fmt.Printf("Routed:   %s to %q by rule %q with CRM stage %q %s\n", timestamp, target_team, routing_rule, crm_stage_at_event, auto_resolved_reason)
+	}
+}
+
+// Run every match method and the routing rules for an event again without storing anything:
+// the key and candidates of each method, the method that wins, the CRM stage and the rule picking the team
+func explainMatchAndRouting(db *DB, m *matcher, routing *routingConfig, id string) {
+	e, err := loadMandateEvent(db, id)
+	if err != nil {
+		log.Fatalf("Cannot find event %s: %s", id, err)
+	}
+
+	fmt.Println("Match methods now, in the order they are tried:")
+	match, trace := m.traceCRMAccount(&e, true)
+	for _, t := range trace {
+		verdict := ""
+		if t.decided && match.ambiguous {
+			verdict = "  <- wins, ambiguous"
+		} else if t.decided {
+			verdict = "  <- wins"
+		}
+		fmt.Printf("  Method %d %s (%s): key %q, %d candidates%s\n", t.method.number, t.method.name, t.method.field, t.key, len(t.candidates), verdict)
+		for _, c := range t.candidates {
+			fmt.Printf("      crm_id %s  account %s  %s  stage %s  score %.3f  %s\n",
+				c.account.crm_id, c.account.crm_account_number, c.account.crm_name, c.account.crm_stage_name, c.score, c.note)
+		}
+	}
+	if match.method == 0 {
+		fmt.Println("  No method found a CRM account")
+	} else if !match.ambiguous {
+		stage := m.stageAt(match.account, e.created_at)
+		fmt.Printf("Winner:   %s: crm_id %s, account %s, CRM stage now %q, on the day of the event %q\n",
+			matchMethodName(match.method), match.account.crm_id, match.account.crm_account_number, match.account.crm_stage_name, stage)
+		match.account.crm_stage_name = stage
+		if match.deleted_at != "" {
+			fmt.Println("          the CRM account is missing from the CRM since", match.deleted_at)
+		}
+	}
+
+	c := &mandateCase{events: []*mandateEvent{&e}}
+	if reason := autoResolveReason(db, c, time.Now().Format("2006-01-02")); reason != "" {
+		fmt.Printf("Routing:  %q, the case is closed by cm: %s\n", autoResolvedTeam, reason)
+		return
+	}
+	fmt.Println("Routing rules now, in order:")
+	for i := range routing.Rules {
+		rule := &routing.Rules[i]
+		if rule.applies(&e, match) {
+			fmt.Printf("  %-30s applies  -> team %q\n", rule.Name, rule.Team)
+			return
+		}
+		fmt.Printf("  %-30s doesn't apply\n", rule.Name)
+	}
+	fmt.Printf("  no rule applies       -> default team %q\n", routing.DefaultTeam)
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// The team a case was routed to on a processing day, with everything its export row needs:
+// the latest event of the case, the case, and the match result with the CRM account
+type caseRouting struct {
+	timestamp            string
+	event                mandateEvent
+	c                    mandateCase
+	case_type            string
+	match                matchResult
+	match_candidates     string
+	target_team          string
+	routing_rule         string
+	auto_resolved_reason string
+	crm_stage_at_event   string
+	routed_at            string
+	event_history        string
+	event_count          int
+}
+
+// A column of the team files: its header and its value for a case
+type caseColumn struct {
+	name  string
+	value func(r *caseRouting) string
+}
+
+// The columns of the team files in their order, also for the header row
+var caseColumns = []caseColumn{
+	{"id", func(r *caseRouting) string { return r.event.id }},
+	{"created_at", func(r *caseRouting) string { return r.event.created_at }},
+	{"resource_type", func(r *caseRouting) string { return r.event.resource_type }},
+	{"action", func(r *caseRouting) string { return r.event.action }},
+	{"details_origin", func(r *caseRouting) string { return r.event.details_origin }},
+	{"details_cause", func(r *caseRouting) string { return r.event.details_cause }},
+	{"details_description", func(r *caseRouting) string { return r.event.details_description }},
+	{"details_scheme", func(r *caseRouting) string { return r.event.details_scheme }},
+	{"details_reason_code", func(r *caseRouting) string { return r.event.details_reason_code }},
+	{"links_previous_customer_bank_account", func(r *caseRouting) string { return r.event.links_previous_customer_bank_account }},
+	{"links_new_customer_bank_account", func(r *caseRouting) string { return r.event.links_new_customer_bank_account }},
+	{"links_parent_event", func(r *caseRouting) string { return r.event.links_parent_event }},
+	{"links_mandate", func(r *caseRouting) string { return r.event.links_mandate }},
+	{"mandates_id", func(r *caseRouting) string { return r.event.mandates_id }},
+	{"mandates_created_at", func(r *caseRouting) string { return r.event.mandates_created_at }},
+	{"mandates_reference", func(r *caseRouting) string { return r.event.mandates_reference }},
+	{"mandates_status", func(r *caseRouting) string { return r.event.mandates_status }},
+	{"mandates_scheme", func(r *caseRouting) string { return r.event.mandates_scheme }},
+	{"mandates_next_possible_charge_date", func(r *caseRouting) string { return r.event.mandates_next_possible_charge_date }},
+	{"mandates_payments_require_approval", func(r *caseRouting) string { return r.event.mandates_payments_require_approval }},
+	{"mandates_links_customer_bank_account", func(r *caseRouting) string { return r.event.mandates_links_customer_bank_account }},
+	{"mandates_links_creditor", func(r *caseRouting) string { return r.event.mandates_links_creditor }},
+	{"customers_id", func(r *caseRouting) string { return r.event.customers_id }},
+	{"customers_given_name", func(r *caseRouting) string { return r.event.customers_given_name }},
+	{"customers_family_name", func(r *caseRouting) string { return r.event.customers_family_name }},
+	{"customers_company_name", func(r *caseRouting) string { return r.event.customers_company_name }},
+	{"customers_metadata_leadID", func(r *caseRouting) string { return r.event.customers_metadata_leadID }},
+	{"customers_metadata_link", func(r *caseRouting) string { return r.event.customers_metadata_link }},
+	{"customers_metadata_xero", func(r *caseRouting) string { return r.event.customers_metadata_xero }},
+	{"mandates_metadata_xero", func(r *caseRouting) string { return r.event.mandates_metadata_xero }},
+	{"imported_at", func(r *caseRouting) string { return r.event.imported_at }},
+	{"customers_name", func(r *caseRouting) string { return r.event.customers_name }},
+	{"crm_account_number", func(r *caseRouting) string { return r.match.account.crm_account_number }},
+	{"crm_id", func(r *caseRouting) string { return r.match.account.crm_id }},
+	{"crm_name", func(r *caseRouting) string { return r.match.account.crm_name }},
+	{"crm_email", func(r *caseRouting) string { return r.match.account.crm_email }},
+	{"crm_premise_address", func(r *caseRouting) string { return r.match.account.crm_premise_address }},
+	{"crm_stage_name", func(r *caseRouting) string { return r.match.account.crm_stage_name }},
+	{"crm_customer_name", func(r *caseRouting) string { return "" }},
+	{"crm_gocardless_id", func(r *caseRouting) string { return r.match.account.crm_gocardless_id }},
+	{"target_team", func(r *caseRouting) string { return r.target_team }},
+	{"crm_zen_user_id", func(r *caseRouting) string { return r.match.account.crm_zen_user_id }},
+	{"match_method", func(r *caseRouting) string { return matchMethodName(r.match.method) }},
+	{"match_score", func(r *caseRouting) string { return strconv.FormatFloat(r.match.score, 'f', 3, 64) }},
+	{"match_ambiguous", func(r *caseRouting) string { return yesOrEmpty(r.match.ambiguous) }},
+	{"match_candidates", func(r *caseRouting) string { return r.match_candidates }},
+	{"match_note", func(r *caseRouting) string { return r.match.note }},
+	{"case_id", func(r *caseRouting) string { return strconv.FormatInt(r.c.case_id, 10) }},
+	{"case_type", func(r *caseRouting) string { return r.case_type }},
+	{"case_opened_at", func(r *caseRouting) string { return r.c.opened_at }},
+	{"event_count", func(r *caseRouting) string { return strconv.Itoa(r.event_count) }},
+	{"event_history", func(r *caseRouting) string { return r.event_history }},
+	{"routing_rule", func(r *caseRouting) string { return r.routing_rule }},
+	{"case_status", func(r *caseRouting) string { return r.c.status }},
+	{"case_owner", func(r *caseRouting) string { return r.c.owner }},
+	{"case_notes", func(r *caseRouting) string { return r.c.notes }},
+	{"case_age_days", func(r *caseRouting) string { return strconv.Itoa(r.c.ageInDays(r.timestamp)) }},
+	{"auto_resolved_reason", func(r *caseRouting) string { return r.auto_resolved_reason }},
+	{"reason_category", func(r *caseRouting) string { return classifyReason(&r.event).category }},
+	{"reason_severity", func(r *caseRouting) string { return classifyReason(&r.event).severity }},
+	{"reason_explanation", func(r *caseRouting) string { return classifyReason(&r.event).explanation }},
+	{"suggested_action", func(r *caseRouting) string { return classifyReason(&r.event).suggested_action }},
+	{"crm_stage_at_event", func(r *caseRouting) string { return r.crm_stage_at_event }},
+	{"crm_deleted_at", func(r *caseRouting) string { return r.match.deleted_at }},
+}
+
+// How the export files are written: as "csv" or "xlsx" workbook, for CSV the delimiter between
+// the values and whether they start with a UTF-8 byte order mark, which Excel needs to recognise UTF-8,
+// for XLSX whether the team files get a summary sheet
+type exportOptions struct {
+	format    string
+	delimiter rune
+	bom       bool
+	summary   bool
+}
+
+// An export file, CSV or XLSX
+type exportFile interface {
+	write(record []string)
+	close()
+}
+
+// An export file written with RFC 4180 quoting
+type csvFile struct {
+	file   *os.File
+	writer *csv.Writer
+}
+
+// The delimiter of the -delimiter parameter: comma, semicolon (for German Excel) or tab
+func parseDelimiter(name string) rune {
+	switch strings.ToLower(name) {
+	case "comma", ",":
+		return ','
+	case "semicolon", ";":
+		return ';'
+	case "tab", "\\t":
+		return '\t'
+	}
+	log.Fatalf("Invalid -delimiter %q, expected comma, semicolon or tab", name)
+	return ','
+}
+
+// Create an export file in the format of the options with its header row. XLSX files get
+// the extension .xlsx instead of the one given.
+func createExportFile(fileName string, header []string, options exportOptions) exportFile {
+	if options.format == "xlsx" {
+		return createXLSXFile(strings.TrimSuffix(fileName, filepath.Ext(fileName))+".xlsx", header, options.summary)
+	}
+	return createCSVFile(fileName, header, options)
+}
+
+// Check the -format parameter
+func parseFormat(format string) string {
+	format = strings.ToLower(format)
+	if format != "csv" && format != "xlsx" {
+		log.Fatalf("Invalid -format %q, expected csv or xlsx", format)
+	}
+	return format
+}
+
+// Create a CSV export file and write its header row
+func createCSVFile(fileName string, header []string, options exportOptions) *csvFile {
+	file, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		log.Fatalf("Cannot create export file: %s %s", fileName, err)
+	}
+	if options.bom {
+		if _, err = file.WriteString("\uFEFF"); err != nil {
+			log.Fatalf("Cannot write export file: %s %s", fileName, err)
+		}
+	}
+	writer := csv.NewWriter(file)
+	writer.Comma = options.delimiter
+	writer.UseCRLF = true
+	f := &csvFile{file: file, writer: writer}
+	f.write(header)
+	return f
+}
+
+// Write one row to an export file
+func (f *csvFile) write(record []string) {
+	if err := f.writer.Write(record); err != nil {
+		log.Fatalf("Cannot write export file: %s %s", f.file.Name(), err)
+	}
+}
+
+// Flush and close an export file
+func (f *csvFile) close() {
+	f.writer.Flush()
+	if err := f.writer.Error(); err != nil {
+		log.Fatalf("Cannot write export file: %s %s", f.file.Name(), err)
+	}
+	if err := f.file.Close(); err != nil {
+		log.Fatalf("Cannot close export file: %s %s", f.file.Name(), err)
+	}
+}
+
+// The teams whose cases are written, when the file of team is exported: all teams if team is empty,
+// else team and the teams sharing its file, e.g. the teams of the to-check file
+func exportTeams(teamFiles map[string]string, team string) map[string]bool {
+	teams := make(map[string]bool)
+	for name, fileName := range teamFiles {
+		if team == "" || fileName == teamFiles[team] {
+			teams[name] = true
+		}
+	}
+	return teams
+}
+
+// Export the mandate cases routed on day timestamp (YYYY-MM-DD) to the file of their team in teamFiles,
+// only the file of one team, if team isn't empty, with the cases of all teams sharing it
+func exportMandateCases(db *DB, timestamp string, teamFiles map[string]string, team string, options exportOptions) {
+	header := make([]string, len(caseColumns))
+	for i, column := range caseColumns {
+		header[i] = column.name
+	}
+
+	// prepare the file of every team, e.g. "mandates-to-process-by-pre-installation-team-YYYY-MM-DD.csv",
+	// teams sharing a file write to the same one
+	teams := exportTeams(teamFiles, team)
+	targetFiles := make(map[string]exportFile)
+	for name, fileName := range teamFiles {
+		if !teams[name] {
+			continue
+		}
+		if _, ok := targetFiles[fileName]; !ok {
+			targetFiles[fileName] = createExportFile(fileName, header, options)
+		}
+		fmt.Println("Export:", name, "to", fileName)
+	}
+
+	routings := loadCaseRoutings(db, timestamp, "")
+	for i := range routings {
+		r := &routings[i]
+		if team != "" && !teams[r.target_team] {
+			continue
+		}
+		targetFile, ok := targetFiles[teamFiles[r.target_team]]
+		if !ok {
+			fmt.Println("ERROR:   No file for team", r.target_team, "of case", r.c.case_id, "in the routing rules")
+			continue
+		}
+		record := make([]string, len(caseColumns))
+		for j, column := range caseColumns {
+			record[j] = column.value(r)
+		}
+		targetFile.write(record)
+	}
+	for _, targetFile := range targetFiles {
+		targetFile.close()
+	}
+	fmt.Println("***********************************************************")
+	fmt.Println("EXPORTING MANDATE CASES                          --   ended")
+	fmt.Println("***********************************************************")
+	fmt.Println(" ")
+}
+
+// Remove the routings of a processing day before it is processed (again)
+func clearCaseRoutings(db *DB, timestamp string) {
+	if _, err := db.Exec(`DELETE FROM caseRoutings WHERE timestamp = ?`, timestamp); err != nil {
+		log.Fatalf("Delete from table caseRoutings failed for %s: %s", timestamp, err)
+	}
+}
+
+// Store the team of a case on processing day timestamp, exported with its latest event eventId
+func storeCaseRouting(db *DB, timestamp string, c *mandateCase, eventId string, target_team string, routing_rule string, auto_resolved_reason string, crm_stage_at_event string, runTimestamp string) {
+	SQLStoreCaseRouting := `
+		INSERT INTO caseRoutings(timestamp, case_id, event_id, case_type, target_team, routing_rule, auto_resolved_reason, crm_stage_at_event, routed_at)
+		values(?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(timestamp, case_id)
+		DO UPDATE SET
+		    event_id=excluded.event_id,
+		    case_type=excluded.case_type,
+		    target_team=excluded.target_team,
+		    routing_rule=excluded.routing_rule,
+		    auto_resolved_reason=excluded.auto_resolved_reason,
+		    crm_stage_at_event=excluded.crm_stage_at_event,
+		    routed_at=excluded.routed_at
+	`
+	_, err := db.Exec(SQLStoreCaseRouting, timestamp, c.case_id, eventId, c.caseType(), target_team, routing_rule, auto_resolved_reason, crm_stage_at_event, runTimestamp)
+	if err != nil {
+		log.Fatalf("Insert into table caseRoutings failed for case %d: %s", c.case_id, err)
+	}
+}
+
+// Read a mandate event by its id
+func loadMandateEvent(db *DB, id string) (mandateEvent, error) {
+	row, err := db.Query(`SELECT `+mandateEventColumns+` FROM mandateEvents WHERE id = ?`, id)
+	if err != nil {
+		return mandateEvent{}, err
+	}
+	defer row.Close()
+	if !row.Next() {
+		if err = row.Err(); err != nil {
+			return mandateEvent{}, err
+		}
+		return mandateEvent{}, sql.ErrNoRows
+	}
+	return scanMandateEvent(row)
+}
+
+// The routings of processing day timestamp in the order they were processed, of one team only,
+// if team isn't empty. The case status and the CRM account are the current ones.
+func loadCaseRoutings(db *DB, timestamp string, team string) []caseRouting {
+	SQLGetCaseRoutings := `
+		SELECT caseRoutings.timestamp, caseRoutings.event_id, caseRoutings.case_id, case_type,
+		    target_team, routing_rule, caseRoutings.auto_resolved_reason, IFNULL(crm_stage_at_event, ''), routed_at,
+		    cases.opened_at, cases.status, cases.owner, cases.notes,
+		    IFNULL(match_method, 0), IFNULL(match_key, ''), IFNULL(match_score, 0),
+		    IFNULL(match_ambiguous, 0), IFNULL(match_candidates, ''), IFNULL(match_note, ''),
+		    IFNULL(crmAccounts.crm_account_number, ''), IFNULL(crmAccounts.crm_id, ''),
+		    IFNULL(crmAccounts.crm_name, ''), IFNULL(crmAccounts.crm_email, ''),
+		    IFNULL(crmAccounts.crm_premise_address, ''), IFNULL(crmAccounts.crm_stage_name, ''),
+		    IFNULL(crmAccounts.crm_gocardless_id, ''), IFNULL(crmAccounts.crm_zen_user_id, ''),
+		    IFNULL(crmAccounts.crm_deleted_at, '')
+		FROM caseRoutings
+		INNER JOIN cases ON cases.case_id = caseRoutings.case_id
+		LEFT JOIN matchResults ON matchResults.event_id = caseRoutings.event_id
+		LEFT JOIN crmAccounts ON crmAccounts.crm_id = matchResults.crm_id AND matchResults.crm_id != ''
+		WHERE caseRoutings.timestamp = ? AND (? = '' OR target_team = ?)
+		ORDER BY caseRoutings.rowid`
+	row, err := db.Query(SQLGetCaseRoutings, timestamp, team, team)
+	if err != nil {
+		log.Fatalf("Cannot read caseRoutings of %s: %s", timestamp, err)
+	}
+	var routings []caseRouting
+	for row.Next() {
+		var r caseRouting
+		crm := &r.match.account
+		err = row.Scan(&r.timestamp, &r.event.id, &r.c.case_id, &r.case_type,
+			&r.target_team, &r.routing_rule, &r.auto_resolved_reason, &r.crm_stage_at_event, &r.routed_at,
+			&r.c.opened_at, &r.c.status, &r.c.owner, &r.c.notes,
+			&r.match.method, &r.match.key, &r.match.score, &r.match.ambiguous, &r.match_candidates, &r.match.note,
+			&crm.crm_account_number, &crm.crm_id, &crm.crm_name, &crm.crm_email,
+			&crm.crm_premise_address, &crm.crm_stage_name, &crm.crm_gocardless_id, &crm.crm_zen_user_id,
+			&r.match.deleted_at)
+		if err != nil {
+			log.Fatalf("Cannot read caseRoutings of %s: %s", timestamp, err)
+		}
+		routings = append(routings, r)
+	}
+	row.Close()
+
+	for i := range routings {
+		r := &routings[i]
+		if r.event, err = loadMandateEvent(db, r.event.id); err != nil {
+			log.Fatalf("Cannot read event %s of case %d: %s", r.event.id, r.c.case_id, err)
+		}
+		r.event_history, r.event_count = caseEventHistory(db, r.c.case_id)
+	}
+	return routings
+}
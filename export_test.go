@@ -0,0 +1,58 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExportTeams(t *testing.T) {
+	teamFiles := map[string]string{
+		"Pre-Installation":           "pre.csv",
+		"Post-Installation":          "post.csv",
+		"To check - ambiguous match": "check.csv",
+		"No action - Inactive":       "check.csv",
+		"No action - at our request": "check.csv",
+	}
+	tests := []struct {
+		team string
+		want map[string]bool
+	}{
+		{"", map[string]bool{"Pre-Installation": true, "Post-Installation": true, "To check - ambiguous match": true,
+			"No action - Inactive": true, "No action - at our request": true}},
+		{"Post-Installation", map[string]bool{"Post-Installation": true}},
+		{"No action - Inactive", map[string]bool{"To check - ambiguous match": true, "No action - Inactive": true,
+			"No action - at our request": true}},
+	}
+	for _, tt := range tests {
+		if got := exportTeams(teamFiles, tt.team); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("exportTeams(%q) = %v, want %v", tt.team, got, tt.want)
+		}
+	}
+}
+
+// The columns the team files had before cm added its own: the teams' spreadsheets rely on their
+// positions, new columns are appended after them
+const teamFileBaseColumns = "id,created_at,resource_type,action,details_origin,details_cause,details_description,details_scheme," +
+	"details_reason_code,links_previous_customer_bank_account,links_new_customer_bank_account,links_parent_event,links_mandate," +
+	"mandates_id,mandates_created_at,mandates_reference,mandates_status,mandates_scheme,mandates_next_possible_charge_date," +
+	"mandates_payments_require_approval,mandates_links_customer_bank_account,mandates_links_creditor,customers_id," +
+	"customers_given_name,customers_family_name,customers_company_name,customers_metadata_leadID,customers_metadata_link," +
+	"customers_metadata_xero,mandates_metadata_xero,imported_at,customers_name,crm_account_number,crm_id,crm_name,crm_email," +
+	"crm_premise_address,crm_stage_name,crm_customer_name,crm_gocardless_id,target_team,crm_zen_user_id"
+
+func TestCaseColumns(t *testing.T) {
+	var names []string
+	seen := make(map[string]bool)
+	for _, column := range caseColumns {
+		if seen[column.name] {
+			t.Errorf("column %s is in the team files twice", column.name)
+		}
+		seen[column.name] = true
+		names = append(names, column.name)
+	}
+	header := strings.Join(names, ",")
+	if !strings.HasPrefix(header, teamFileBaseColumns+",") {
+		t.Errorf("the team files don't start with their original columns:\n%s\nwant\n%s,...", header, teamFileBaseColumns)
+	}
+}
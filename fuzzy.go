@@ -0,0 +1,116 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Titles and salutations which are not part of a customer's name
+var nameTitles = map[string]bool{
+	"mr": true, "mrs": true, "ms": true, "miss": true, "mx": true, "dr": true, "prof": true,
+	"sir": true, "dame": true, "herr": true, "frau": true, "dipl": true, "ing": true,
+}
+
+// Letters which are written differently depending on keyboard and system
+var nameTransliterations = map[rune]string{
+	'ä': "ae", 'ö': "oe", 'ü': "ue", 'ß': "ss", 'æ': "ae", 'ø': "o", 'å': "a",
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ç': "c", 'è': "e", 'é': "e", 'ê': "e", 'ë': "e",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ñ': "n", 'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o",
+	'ù': "u", 'ú': "u", 'û': "u", 'ý': "y", 'ÿ': "y", 'ł': "l", 'š': "s", 'ž': "z", 'č': "c",
+}
+
+// Reduce a name to lower case words without titles, umlauts, diacritics and
+// punctuation, e.g. "Dr. Jürgen  MÜLLER" -> "juergen mueller"
+func normalizeName(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if t, ok := nameTransliterations[r]; ok {
+			b.WriteString(t)
+		} else if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune(' ')
+		}
+	}
+	var words []string
+	for _, word := range strings.Fields(b.String()) {
+		if !nameTitles[word] {
+			words = append(words, word)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// Normalized name with its words sorted, so that swapped first and last names compare equal
+func nameKey(name string) string {
+	words := strings.Fields(normalizeName(name))
+	sort.Strings(words)
+	return strings.Join(words, " ")
+}
+
+// Jaro-Winkler similarity of two strings, from 0 (nothing in common) to 1 (equal)
+func jaroWinkler(a string, b string) float64 {
+	s1, s2 := []rune(a), []rune(b)
+	if len(s1) == 0 || len(s2) == 0 {
+		if len(s1) == len(s2) {
+			return 1
+		}
+		return 0
+	}
+
+	window := len(s1)
+	if len(s2) > window {
+		window = len(s2)
+	}
+	window = window/2 - 1
+	if window < 0 {
+		window = 0
+	}
+
+	matched1 := make([]bool, len(s1))
+	matched2 := make([]bool, len(s2))
+	matches := 0
+	for i := range s1 {
+		from, to := i-window, i+window+1
+		if from < 0 {
+			from = 0
+		}
+		if to > len(s2) {
+			to = len(s2)
+		}
+		for j := from; j < to; j++ {
+			if !matched2[j] && s1[i] == s2[j] {
+				matched1[i], matched2[j] = true, true
+				matches++
+				break
+			}
+		}
+	}
+	if matches == 0 {
+		return 0
+	}
+
+	transpositions, j := 0, 0
+	for i := range s1 {
+		if !matched1[i] {
+			continue
+		}
+		for !matched2[j] {
+			j++
+		}
+		if s1[i] != s2[j] {
+			transpositions++
+		}
+		j++
+	}
+
+	m := float64(matches)
+	jaro := (m/float64(len(s1)) + m/float64(len(s2)) + (m-float64(transpositions)/2)/m) / 3
+
+	prefix := 0
+	for prefix < 4 && prefix < len(s1) && prefix < len(s2) && s1[prefix] == s2[prefix] {
+		prefix++
+	}
+	return jaro + float64(prefix)*0.1*(1-jaro)
+}
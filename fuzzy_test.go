@@ -0,0 +1,48 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestJaroWinkler(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want float64
+	}{
+		{"MARTHA", "MARHTA", 0.961},
+		{"DIXON", "DICKSONX", 0.813},
+		{"DWAYNE", "DUANE", 0.840},
+		{"ABCDEF", "BCADEF", 0.917}, // 3 transpositions count as 1.5
+		{"juergen mueller", "juergen mueller", 1},
+		{"", "", 1},
+		{"abc", "", 0},
+		{"abc", "xyz", 0},
+	}
+	for _, tt := range tests {
+		got := jaroWinkler(tt.a, tt.b)
+		if math.Abs(got-tt.want) > 0.0005 {
+			t.Errorf("jaroWinkler(%q, %q) = %.4f, want %.3f", tt.a, tt.b, got, tt.want)
+		}
+		if back := jaroWinkler(tt.b, tt.a); math.Abs(back-got) > 1e-9 {
+			t.Errorf("jaroWinkler(%q, %q) = %.4f, not symmetric to %.4f", tt.b, tt.a, back, got)
+		}
+	}
+}
+
+func TestNameKey(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"Dr. Jürgen  MÜLLER", "juergen mueller"},
+		{"Müller, Jürgen", "juergen mueller"},
+		{"Mr John O'Neill", "john neill o"},
+		{"Frau Anna-Lena Weiß", "anna lena weiss"},
+		{"  ", ""},
+	}
+	for _, tt := range tests {
+		if got := nameKey(tt.name); got != tt.want {
+			t.Errorf("nameKey(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -2,4 +2,4 @@ module github.com/tobkle/cm
 
 go 1.18
 
-require github.com/mattn/go-sqlite3 v1.14.13 // indirect
+require github.com/mattn/go-sqlite3 v1.14.13
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"unicode/utf8"
+)
+
+// How an input CSV file is read: the delimiter between the values and the encoding of the text,
+// "utf-8" or "windows-1252". A zero delimiter or empty encoding is detected from the file.
+type inputFormat struct {
+	delimiter rune
+	encoding  string
+}
+
+// The characters of the bytes 0x80 to 0x9F in Windows-1252, which Latin-1 has as control characters.
+// The bytes 0xA0 to 0xFF are the same characters in both and in Unicode. The five bytes Windows-1252
+// leaves undefined are kept as the control characters of Latin-1.
+var windows1252 = [32]rune{
+	'€', '\u0081', '‚', 'ƒ', '„', '…', '†', '‡',
+	'ˆ', '‰', 'Š', '‹', 'Œ', '\u008D', 'Ž', '\u008F',
+	'\u0090', '‘', '’', '“', '”', '•', '–', '—',
+	'˜', '™', 'š', '›', 'œ', '\u009D', 'ž', 'Ÿ',
+}
+
+// Transcode Windows-1252 (or Latin-1) text to UTF-8
+func decodeWindows1252(data []byte) []byte {
+	var b bytes.Buffer
+	b.Grow(len(data) + len(data)/8)
+	for _, c := range data {
+		switch {
+		case c < 0x80:
+			b.WriteByte(c)
+		case c < 0xA0:
+			b.WriteRune(windows1252[c-0x80])
+		default:
+			b.WriteRune(rune(c))
+		}
+	}
+	return b.Bytes()
+}
+
+// The delimiter of the header row: the one of comma, semicolon and tab found most often
+// outside quotes, comma if there is none
+func sniffDelimiter(data []byte) rune {
+	counts := make(map[rune]int)
+	quoted := false
+	for _, c := range data {
+		if c == '"' {
+			quoted = !quoted
+		}
+		if !quoted && (c == '\n' || c == '\r') {
+			break
+		}
+		if !quoted && (c == ',' || c == ';' || c == '\t') {
+			counts[rune(c)]++
+		}
+	}
+	delimiter := ','
+	for _, d := range []rune{';', '\t'} {
+		if counts[d] > counts[delimiter] {
+			delimiter = d
+		}
+	}
+	return delimiter
+}
+
+// Read an input CSV file in the given format: a UTF-8 byte order mark is removed, text which is
+// not valid UTF-8 is read as Windows-1252 (as saved by Excel on German Windows) and the delimiter
+// is taken from the header row, unless the format gives them.
+func readCSVFile(file io.Reader, csvFileName string, format inputFormat) *csv.Reader {
+	data, err := io.ReadAll(file)
+	if err != nil {
+		log.Fatalf("Cannot read file: %s %s", csvFileName, err)
+	}
+	data = bytes.TrimPrefix(data, []byte("\xEF\xBB\xBF"))
+
+	encoding := format.encoding
+	if encoding == "" {
+		encoding = "utf-8"
+		if !utf8.Valid(data) {
+			encoding = "windows-1252"
+		}
+	}
+	if encoding == "windows-1252" {
+		data = decodeWindows1252(data)
+	}
+	delimiter := format.delimiter
+	if delimiter == 0 {
+		delimiter = sniffDelimiter(data)
+	}
+	if encoding != "utf-8" || delimiter != ',' {
+		fmt.Printf("SUCCESS: Reading %s as %s with delimiter %q\n", csvFileName, encoding, delimiter)
+	}
+
+	recordData := csv.NewReader(bytes.NewReader(data))
+	recordData.Comma = delimiter
+	recordData.FieldsPerRecord = -1
+	return recordData
+}
+
+// The format of the -delimiter and -encoding parameters of an input file, "auto" to detect them
+func parseInputFormat(delimiter string, encoding string) inputFormat {
+	var format inputFormat
+	if delimiter != "" && !strings.EqualFold(delimiter, "auto") {
+		format.delimiter = parseDelimiter(delimiter)
+	}
+	switch strings.ToLower(encoding) {
+	case "", "auto":
+	case "utf-8", "utf8":
+		format.encoding = "utf-8"
+	case "windows-1252", "cp1252", "latin-1", "latin1", "iso-8859-1":
+		format.encoding = "windows-1252"
+	default:
+		log.Fatalf("Invalid -encoding %q, expected auto, utf-8 or windows-1252", encoding)
+	}
+	return format
+}
+
+// Add the parameters -<file>-delimiter and -<file>-encoding for each input file, or -delimiter
+// and -encoding for the file "". The returned function gives the formats per file after flags.Parse.
+func inputFormatFlags(flags *flag.FlagSet, files ...string) func() map[string]inputFormat {
+	delimiters := make(map[string]*string)
+	encodings := make(map[string]*string)
+	for _, file := range files {
+		prefix, name := file+"-", file+" "
+		if file == "" {
+			prefix, name = "", ""
+		}
+		delimiters[file] = flags.String(prefix+"delimiter", "auto", "Delimiter of the "+name+"input file: auto, comma, semicolon or tab")
+		encodings[file] = flags.String(prefix+"encoding", "auto", "Encoding of the "+name+"input file: auto, utf-8 or windows-1252 (latin-1)")
+	}
+	return func() map[string]inputFormat {
+		formats := make(map[string]inputFormat)
+		for _, file := range files {
+			formats[file] = parseInputFormat(*delimiters[file], *encodings[file])
+		}
+		return formats
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSniffDelimiter(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want rune
+	}{
+		{"comma", "id,created_at,action\nEV1;x;y;z\n", ','},
+		{"semicolon", "id;created_at;action\nEV1,x,y,z\n", ';'},
+		{"tab", "id\tcreated_at\taction\n", '\t'},
+		{"quoted delimiters", "\"a;b;c\",id,created_at\n", ','},
+		{"quoted line break", "\"a\nb\";id;created_at\n", ';'},
+		{"carriage return", "id;created_at\r\nEV1,x,y,z\r\n", ';'},
+		{"more semicolons than commas", "\"Name, first\";id;created_at\n", ';'},
+		{"one column", "id\nEV1\n", ','},
+		{"empty", "", ','},
+	}
+	for _, tt := range tests {
+		if got := sniffDelimiter([]byte(tt.data)); got != tt.want {
+			t.Errorf("%s: sniffDelimiter(%q) = %q, want %q", tt.name, tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeWindows1252(t *testing.T) {
+	tests := []struct {
+		data, want string
+	}{
+		{"M\xfcller", "Müller"},
+		{"Stra\xdfe \x80 5", "Straße € 5"},
+		{"\x84Zitat\x93 \x96 \x85", "„Zitat“ – …"},
+		{"\x81\x8d", "\u0081\u008d"},
+		{"plain ascii", "plain ascii"},
+	}
+	for _, tt := range tests {
+		if got := string(decodeWindows1252([]byte(tt.data))); got != tt.want {
+			t.Errorf("decodeWindows1252(%q) = %q, want %q", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestReadCSVFile(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		format inputFormat
+		want   [][]string
+	}{
+		{"utf-8", "id,name\nEV1,Müller\n", inputFormat{}, [][]string{{"id", "name"}, {"EV1", "Müller"}}},
+		{"byte order mark", "\xef\xbb\xbfid;name\nEV1;Müller\n", inputFormat{}, [][]string{{"id", "name"}, {"EV1", "Müller"}}},
+		{"windows-1252", "id;name\r\nEV1;M\xfcller\r\n", inputFormat{}, [][]string{{"id", "name"}, {"EV1", "Müller"}}},
+		{"given delimiter", "id;name,first\nEV1;Müller,Jürgen\n", inputFormat{delimiter: ','}, [][]string{{"id;name", "first"}, {"EV1;Müller", "Jürgen"}}},
+		{"given encoding", "id,name\nEV1,MÃ¼ller\n", inputFormat{encoding: "windows-1252"}, [][]string{{"id", "name"}, {"EV1", "MÃƒÂ¼ller"}}},
+		{"other number of fields", "id,name\nEV1\n", inputFormat{}, [][]string{{"id", "name"}, {"EV1"}}},
+	}
+	for _, tt := range tests {
+		records, err := readCSVFile(strings.NewReader(tt.data), tt.name+".csv", tt.format).ReadAll()
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(records, tt.want) {
+			t.Errorf("%s: read %q, want %q", tt.name, records, tt.want)
+		}
+	}
+}
+
+func TestParseInputFormat(t *testing.T) {
+	tests := []struct {
+		delimiter, encoding string
+		want                inputFormat
+	}{
+		{"auto", "auto", inputFormat{}},
+		{"", "", inputFormat{}},
+		{"AUTO", "Auto", inputFormat{}},
+		{"semicolon", "UTF8", inputFormat{delimiter: ';', encoding: "utf-8"}},
+		{"tab", "latin-1", inputFormat{delimiter: '\t', encoding: "windows-1252"}},
+		{",", "cp1252", inputFormat{delimiter: ',', encoding: "windows-1252"}},
+	}
+	for _, tt := range tests {
+		if got := parseInputFormat(tt.delimiter, tt.encoding); got != tt.want {
+			t.Errorf("parseInputFormat(%q, %q) = %+v, want %+v", tt.delimiter, tt.encoding, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// A CRM account as returned by one of the match methods
+type crmAccount struct {
+	crm_account_number  string
+	crm_id              string
+	crm_name            string
+	crm_email           string
+	crm_premise_address string
+	crm_stage_name      string
+	crm_gocardless_id   string
+	crm_zen_user_id     string
+}
+
+// One way to find the CRM account of a mandate event: the event field used as
+// key and either the query returning the CRM accounts for it (?1 is the key)
+// with a note on each, or a search over the CRM names held in memory
+type matchMethod struct {
+	number int
+	name   string
+	field  string
+	key    func(e *mandateEvent) string
+	query  string
+	search func(m *matcher, e *mandateEvent) []matchCandidate
+}
+
+// A CRM account found by a match method, how similar it is (1 for all exact methods)
+// and why the match needs a closer look, if it does
+type matchCandidate struct {
+	account crmAccount
+	score   float64
+	note    string
+}
+
+// Which method resolved a mandate event, with which key, the account found
+// and how similar it is. If the method found more than one account, the result
+// is ambiguous: no account is chosen and all candidates are kept.
+type matchResult struct {
+	method     int
+	key        string
+	score      float64
+	account    crmAccount
+	note       string
+	deleted_at string
+	ambiguous  bool
+	candidates []matchCandidate
+}
+
+// A CRM account with the name key used by the name methods
+type crmName struct {
+	account crmAccount
+	key     string
+}
+
+// State of the match methods for one processing run, with the queries of the
+// methods prepared once per run
+type matcher struct {
+	db             *DB
+	fuzzyThreshold float64
+	statements     map[int]*CMD
+	override       *CMD
+	stages         *CMD
+	deleted        *CMD
+	names          []crmName
+	namesLoaded    bool
+}
+
+// Columns selected by every match method, in the order of scanCRMAccount
+const crmAccountColumns = `crm_account_number, crm_id, crm_name, crm_email, crm_premise_address, crm_stage_name, crm_gocardless_id, crm_zen_user_id`
+
+// The match methods in the order they are tried, see README "Methods to find a CRM account number".
+// The number of a method is stored in matchResults.match_method and logged as "Method <number>",
+// so it never changes: the manual overrides come first, but got the next free number 7.
+// Number 0 is no match.
+var matchMethods = []matchMethod{
+	{
+		number: 7, name: "manual override", field: "mandates_id/customers_id",
+		key:    func(e *mandateEvent) string { return e.mandates_id + " " + e.customers_id },
+		search: (*matcher).searchOverride,
+	},
+	{
+		number: 1, name: "leadID", field: "customers_metadata_leadID",
+		key: func(e *mandateEvent) string { return e.customers_metadata_leadID },
+		query: `SELECT DISTINCT ` + crmAccountColumns + `, ''
+			FROM crmAccounts
+			WHERE ( crm_id             = ?1
+			  OR    crm_account_number = ?1
+			)`,
+	},
+	{
+		number: 2, name: "elevate mandate reference", field: "mandates_id",
+		key: func(e *mandateEvent) string { return e.mandates_id },
+		query: `SELECT DISTINCT ` + crmAccountColumns + `,
+			    CASE WHEN superseded_at != ''
+			    THEN 'superseded mandate reference, replaced by ' || IFNULL(elevateAccounts.elevate_mandate_reference, '') || ' on ' || superseded_at
+			    ELSE '' END
+			FROM elevateMandateReferences
+			INNER JOIN crmAccounts
+			ON elevateMandateReferences.elevate_account_number = crm_account_number
+			LEFT JOIN elevateAccounts
+			ON elevateAccounts.elevate_account_number = elevateMandateReferences.elevate_account_number
+			WHERE elevateMandateReferences.elevate_mandate_reference = ?1`,
+	},
+	{
+		number: 3, name: "gocardless customer id", field: "customers_id",
+		key: func(e *mandateEvent) string { return e.customers_id },
+		query: `SELECT DISTINCT ` + crmAccountColumns + `, ''
+			FROM crmAccounts
+			WHERE crm_gocardless_id = ?1`,
+	},
+	{
+		number: 4, name: "exact name", field: "customers_name",
+		key: func(e *mandateEvent) string { return e.customers_name },
+		query: `SELECT DISTINCT ` + crmAccountColumns + `, ''
+			FROM crmAccounts
+			WHERE crm_name = ?1`,
+	},
+	{
+		number: 5, name: "normalized name", field: "customers_name",
+		key:    func(e *mandateEvent) string { return e.customers_name + " " + e.customers_company_name },
+		search: (*matcher).searchNormalizedName,
+	},
+	{
+		number: 6, name: "fuzzy name", field: "customers_name",
+		key:    func(e *mandateEvent) string { return e.customers_name + " " + e.customers_company_name },
+		search: (*matcher).searchFuzzyName,
+	},
+}
+
+// Scan a row selected with crmAccountColumns into a crmAccount, and the columns
+// selected after them into more
+func scanCRMAccount(row *sql.Rows, more ...interface{}) (crmAccount, error) {
+	var account crmAccount
+	err := row.Scan(append([]interface{}{&account.crm_account_number, &account.crm_id, &account.crm_name, &account.crm_email,
+		&account.crm_premise_address, &account.crm_stage_name, &account.crm_gocardless_id, &account.crm_zen_user_id}, more...)...)
+	return account, err
+}
+
+// Prepare the match methods for a processing run
+func newMatcher(db *DB, fuzzyThreshold float64) *matcher {
+	m := &matcher{db: db, fuzzyThreshold: fuzzyThreshold, statements: make(map[int]*CMD)}
+	for _, method := range matchMethods {
+		if method.query != "" {
+			m.statements[method.number] = prepareSQL("match method "+method.name, method.query, db)
+		}
+	}
+	m.override = prepareSQL("match method manual override", SQLGetOverride, db)
+	m.stages = prepareSQL("select from crmAccountHistory", SQLGetCRMStageAt, db)
+	m.deleted = prepareSQL("select from crmAccounts", `SELECT IFNULL(crm_deleted_at, '') FROM crmAccounts WHERE crm_id = ?`, db)
+	return m
+}
+
+// Close the prepared queries of the match methods at the end of a run
+func (m *matcher) close() {
+	for _, statement := range m.statements {
+		statement.Close()
+	}
+	m.override.Close()
+	m.stages.Close()
+	m.deleted.Close()
+}
+
+// Name of a match method for the exports, "none" if no method found an account
+func matchMethodName(number int) string {
+	for _, method := range matchMethods {
+		if method.number == number {
+			return method.name
+		}
+	}
+	return "none"
+}
+
+// Whether the same CRM account is already in a list of candidates
+func hasCandidate(candidates []matchCandidate, account crmAccount) bool {
+	for _, c := range candidates {
+		if c.account.crm_id == account.crm_id && c.account.crm_account_number == account.crm_account_number {
+			return true
+		}
+	}
+	return false
+}
+
+// Add a candidate to a list, unless the same CRM account is already in it
+func addCandidate(candidates []matchCandidate, account crmAccount, score float64) []matchCandidate {
+	if hasCandidate(candidates, account) {
+		return candidates
+	}
+	return append(candidates, matchCandidate{account: account, score: score})
+}
+
+// Run the lookup of a match method for a key, return all CRM accounts found.
+// A failing query stops the run, it must not look like a missing CRM account.
+func (m *matcher) lookupCRMAccounts(method matchMethod, key string) []matchCandidate {
+	var candidates []matchCandidate
+	row, err := m.statements[method.number].Query(key)
+	if err != nil {
+		log.Fatalf("Method %d %s failed for key %q: %s", method.number, method.name, key, err)
+	}
+	defer row.Close()
+	for row.Next() {
+		var note string
+		account, err := scanCRMAccount(row, &note)
+		if err != nil {
+			log.Fatalf("Method %d %s failed for key %q: %s", method.number, method.name, key, err)
+		}
+		if (account.crm_id != "" || account.crm_account_number != "") && !hasCandidate(candidates, account) {
+			candidates = append(candidates, matchCandidate{account: account, score: 1, note: note})
+		}
+	}
+	if err = row.Err(); err != nil {
+		log.Fatalf("Method %d %s failed for key %q: %s", method.number, method.name, key, err)
+	}
+	return candidates
+}
+
+// Load the names of all CRM accounts once per run for the name methods
+func (m *matcher) crmNames() []crmName {
+	if m.namesLoaded {
+		return m.names
+	}
+	row, err := m.db.Query(`SELECT ` + crmAccountColumns + ` FROM crmAccounts WHERE crm_name != ''`)
+	if err != nil {
+		log.Fatalf("Cannot read crmAccounts names: %s", err)
+	}
+	defer row.Close()
+	for row.Next() {
+		account, err := scanCRMAccount(row)
+		if err != nil {
+			log.Fatalf("Cannot read crmAccounts names: %s", err)
+		}
+		if key := nameKey(account.crm_name); key != "" {
+			m.names = append(m.names, crmName{account: account, key: key})
+		}
+	}
+	m.namesLoaded = true
+	return m.names
+}
+
+// Name keys of a mandate event: the customer's name and the company name
+func eventNameKeys(e *mandateEvent) []string {
+	var keys []string
+	for _, name := range []string{e.customers_given_name + " " + e.customers_family_name, e.customers_company_name} {
+		if key := nameKey(name); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Method 5: the customer's or company name equals a CRM name, ignoring case,
+// spacing, umlauts, diacritics, titles and the order of the words
+func (m *matcher) searchNormalizedName(e *mandateEvent) []matchCandidate {
+	var candidates []matchCandidate
+	for _, key := range eventNameKeys(e) {
+		for _, name := range m.crmNames() {
+			if name.key == key {
+				candidates = addCandidate(candidates, name.account, 1)
+			}
+		}
+	}
+	return candidates
+}
+
+// Method 6: the CRM names similar to the customer's or company name,
+// whose Jaro-Winkler similarity reaches the fuzzy threshold
+func (m *matcher) searchFuzzyName(e *mandateEvent) []matchCandidate {
+	var candidates []matchCandidate
+	for _, key := range eventNameKeys(e) {
+		for _, name := range m.crmNames() {
+			if score := jaroWinkler(key, name.key); score >= m.fuzzyThreshold {
+				candidates = addCandidate(candidates, name.account, score)
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].score > candidates[j].score })
+	return candidates
+}
+
+// The key of a match method for the mandate event and the CRM accounts the method finds for it
+func (m *matcher) findCandidates(method matchMethod, e *mandateEvent) (string, []matchCandidate) {
+	key := strings.TrimSpace(method.key(e))
+	if key == "" {
+		return key, nil
+	}
+	if method.search != nil {
+		return key, method.search(m, e)
+	}
+	return key, m.lookupCRMAccounts(method, key)
+}
+
+// A match method tried for a mandate event: its key, the CRM accounts it found and
+// whether it decided the match
+type methodTrace struct {
+	method     matchMethod
+	key        string
+	candidates []matchCandidate
+	decided    bool
+}
+
+// Try the match methods in order until one finds the CRM account of the mandate event.
+// A method finding more than one account ends the search with an ambiguous result.
+// The trace holds the methods tried, with all also the ones after the deciding method.
+func (m *matcher) traceCRMAccount(e *mandateEvent, all bool) (matchResult, []methodTrace) {
+	var result matchResult
+	var trace []methodTrace
+	decided := false
+	for _, method := range matchMethods {
+		if decided && !all {
+			break
+		}
+		key, candidates := m.findCandidates(method, e)
+		t := methodTrace{method: method, key: key, candidates: candidates}
+		if !decided && len(candidates) == 1 {
+			result = matchResult{method: method.number, key: key, score: candidates[0].score, account: candidates[0].account, note: candidates[0].note, candidates: candidates}
+			result.deleted_at = m.deletedAt(result.account)
+			t.decided, decided = true, true
+		} else if !decided && len(candidates) > 1 {
+			result = matchResult{method: method.number, key: key, ambiguous: true, candidates: candidates}
+			t.decided, decided = true, true
+		}
+		trace = append(trace, t)
+	}
+	return result, trace
+}
+
+// Find the CRM account of the mandate event, see traceCRMAccount, and print the methods tried
+func (m *matcher) matchCRMAccount(e *mandateEvent) matchResult {
+	result, trace := m.traceCRMAccount(e, false)
+	for _, t := range trace {
+		switch len(t.candidates) {
+		case 0:
+			fmt.Printf("Method %d: %s: %s  didn't find a crm record\n", t.method.number, t.method.field, t.key)
+		case 1:
+			account, score, note := t.candidates[0].account, t.candidates[0].score, t.candidates[0].note
+			fmt.Printf("Method %d: %s: %s  found crm_id: %s  crm_account_number: %s  score: %.3f  %s\n", t.method.number, t.method.field, t.key, account.crm_id, account.crm_account_number, score, note)
+		default:
+			fmt.Printf("Method %d: %s: %s  found %d crm records, ambiguous: %s\n", t.method.number, t.method.field, t.key, len(t.candidates), result.candidateAccountNumbers())
+		}
+	}
+	if result.deleted_at != "" {
+		fmt.Println("          the CRM account is missing from the CRM since", result.deleted_at)
+	}
+	return result
+}
+
+// Account numbers of all candidates of a match, separated by " | "
+func (r matchResult) candidateAccountNumbers() string {
+	var numbers []string
+	for _, c := range r.candidates {
+		if c.account.crm_account_number != "" {
+			numbers = append(numbers, c.account.crm_account_number)
+		} else {
+			numbers = append(numbers, c.account.crm_id)
+		}
+	}
+	return strings.Join(numbers, " | ")
+}
+
+// Store the match result of a mandate event, replacing the one of an earlier run
+func storeMatchResult(db *DB, eventId string, result matchResult, runTimestamp string) {
+	SQLStoreMatchResult := `
+		INSERT INTO matchResults(event_id, match_method, match_key, crm_id, matched_at, match_score, match_ambiguous, match_candidates, match_note)
+		values(?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(event_id)
+		DO UPDATE SET
+		    match_method=excluded.match_method,
+		    match_key=excluded.match_key,
+		    crm_id=excluded.crm_id,
+		    matched_at=excluded.matched_at,
+		    match_score=excluded.match_score,
+		    match_ambiguous=excluded.match_ambiguous,
+		    match_candidates=excluded.match_candidates,
+		    match_note=excluded.match_note
+	`
+	_, err := db.Exec(SQLStoreMatchResult, eventId, result.method, result.key, result.account.crm_id, runTimestamp,
+		result.score, result.ambiguous, result.candidateAccountNumbers(), result.note)
+	if err != nil {
+		fmt.Println("ERROR:   Insert into table matchResults failed for id =", eventId, err)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import "testing"
+
+func TestTraceCRMAccount(t *testing.T) {
+	db := newTestDatabase(t)
+	for _, a := range [][4]string{{"C1", "A1", "Jane Doe", "CU1"}, {"C3", "A3", "John Smith", ""}, {"C4", "A4", "John Smith", ""}} {
+		_, err := db.Exec(`INSERT INTO crmAccounts(crm_id, crm_account_number, crm_name, crm_gocardless_id, crm_stage_name,
+			crm_email, crm_premise_address, crm_zen_user_id) values(?, ?, ?, ?, 'ACTIVE', '', '', '')`,
+			a[0], a[1], a[2], a[3])
+		if err != nil {
+			t.Fatalf("insert into crmAccounts: %s", err)
+		}
+	}
+	m := newMatcher(db, 0.92)
+	defer m.close()
+
+	// the gocardless customer id decides, the exact name which comes after it finds two accounts
+	e := mandateEvent{id: "EV1", mandates_id: "MD1", customers_id: "CU1", customers_name: "John Smith"}
+	match, trace := m.traceCRMAccount(&e, false)
+	if match.method != 3 || match.account.crm_id != "C1" || match.ambiguous {
+		t.Errorf("match = method %d crm_id %q ambiguous %v, want method 3 crm_id C1", match.method, match.account.crm_id, match.ambiguous)
+	}
+	if last := trace[len(trace)-1]; last.method.number != 3 || !last.decided {
+		t.Errorf("trace ends with method %d decided %v, want the deciding method 3", last.method.number, last.decided)
+	}
+	all, trace := m.traceCRMAccount(&e, true)
+	if all.method != match.method || all.account != match.account || len(trace) != len(matchMethods) {
+		t.Errorf("trace of all methods decided method %d with %d methods, want method %d with %d", all.method, len(trace), match.method, len(matchMethods))
+	}
+	for _, tr := range trace {
+		if tr.decided != (tr.method.number == 3) {
+			t.Errorf("method %d decided %v", tr.method.number, tr.decided)
+		}
+	}
+
+	// two accounts with the name are ambiguous
+	e = mandateEvent{id: "EV2", mandates_id: "MD2", customers_id: "CU8", customers_name: "John Smith"}
+	if match = m.matchCRMAccount(&e); !match.ambiguous || match.method != 4 || len(match.candidates) != 2 {
+		t.Errorf("match = method %d ambiguous %v with %d candidates, want method 4 ambiguous with 2", match.method, match.ambiguous, len(match.candidates))
+	}
+}
+
+func TestOverrideBeforeLeadID(t *testing.T) {
+	tests := []struct {
+		name      string
+		overrides [][3]string
+		method    int
+		crm_id    string
+	}{
+		{"no override", nil, 1, "C1"},
+		{"mandate override", [][3]string{{"mandates_id", "MD1", "C2"}}, 7, "C2"},
+		{"customer override", [][3]string{{"customers_id", "CU1", "C3"}}, 7, "C3"},
+		{"mandate before customer override", [][3]string{{"customers_id", "CU1", "C3"}, {"mandates_id", "MD1", "C2"}}, 7, "C2"},
+		{"override of another mandate", [][3]string{{"mandates_id", "MD9", "C2"}}, 1, "C1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDatabase(t)
+			for _, a := range [][2]string{{"C1", "A1"}, {"C2", "A2"}, {"C3", "A3"}} {
+				_, err := db.Exec(`INSERT INTO crmAccounts(crm_id, crm_account_number, crm_name, crm_gocardless_id, crm_stage_name,
+					crm_email, crm_premise_address, crm_zen_user_id) values(?, ?, 'Name', '', 'ACTIVE', '', '', '')`, a[0], a[1])
+				if err != nil {
+					t.Fatalf("insert into crmAccounts: %s", err)
+				}
+			}
+			for _, o := range tt.overrides {
+				if err := addMatchOverride(db, o[0], o[1], o[2], "test"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			m := newMatcher(db, 0.92)
+			defer m.close()
+
+			e := mandateEvent{id: "EV1", mandates_id: "MD1", customers_id: "CU1", customers_metadata_leadID: "A1", customers_name: "Name"}
+			match := m.matchCRMAccount(&e)
+			if match.method != tt.method || match.account.crm_id != tt.crm_id || match.ambiguous {
+				t.Errorf("match = %s crm_id %q ambiguous %v, want %s crm_id %q",
+					matchMethodName(match.method), match.account.crm_id, match.ambiguous, matchMethodName(tt.method), tt.crm_id)
+			}
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"database/sql"
+	"embed"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migrations in SQL, named <version>_<name>.sql
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// One step of the database schema. A migration is either SQL or Go, and runs
+// together with its entry in schema_version in one transaction.
+type migration struct {
+	version int
+	name    string
+	sql     string
+	apply   func(tx *sql.Tx) error
+}
+
+// Migrations which cannot be written in SQL, as they depend on the state of the database
+var goMigrations = []migration{
+	{version: 2, name: "legacy columns", apply: addLegacyColumns},
+}
+
+// All migrations, ordered by version
+func loadMigrations() []migration {
+	migrations := append([]migration{}, goMigrations...)
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		log.Fatalf("Cannot read migrations: %s", err)
+	}
+	for _, entry := range entries {
+		base := strings.TrimSuffix(entry.Name(), ".sql")
+		number, name, _ := strings.Cut(base, "_")
+		version, err := strconv.Atoi(number)
+		if err != nil {
+			log.Fatalf("Migration %s does not start with its version number", entry.Name())
+		}
+		statements, err := migrationFiles.ReadFile("migrations/" + entry.Name())
+		if err != nil {
+			log.Fatalf("Cannot read migration %s: %s", entry.Name(), err)
+		}
+		migrations = append(migrations, migration{version: version, name: strings.ReplaceAll(name, "_", " "), sql: string(statements)})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].version == migrations[i-1].version {
+			log.Fatalf("Migrations %q and %q have the same version %d", migrations[i-1].name, migrations[i].name, migrations[i].version)
+		}
+	}
+	return migrations
+}
+
+// Versions of the migrations applied to the database, with the time they were applied
+func appliedMigrations(db *DB) map[int]string {
+	applied := make(map[int]string)
+	var count int
+	db.QueryRow(`SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_version'`).Scan(&count)
+	if count == 0 {
+		return applied
+	}
+	row, err := db.Query(`SELECT version, applied_at FROM schema_version`)
+	if err != nil {
+		log.Fatalf("Cannot read schema_version: %s", err)
+	}
+	defer row.Close()
+	for row.Next() {
+		var version int
+		var applied_at string
+		if err := row.Scan(&version, &applied_at); err != nil {
+			log.Fatalf("Cannot read schema_version: %s", err)
+		}
+		applied[version] = applied_at
+	}
+	return applied
+}
+
+// Bring the database to the current schema version. Before the first pending migration
+// a copy of an existing database is saved next to it.
+func migrateDatabase(db *DB) {
+	prepareAndExecuteSQL("create table schema_version", `
+	  CREATE TABLE IF NOT EXISTS schema_version (
+		version       integer primary key,
+		name          text,
+		applied_at    text
+	)`, db)
+
+	applied := appliedMigrations(db)
+	var pending []migration
+	for _, m := range loadMigrations() {
+		if _, ok := applied[m.version]; !ok {
+			pending = append(pending, m)
+		}
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	backup := backupDatabase(db)
+	for _, m := range pending {
+		if err := applyMigration(db, m); err != nil {
+			if backup != "" {
+				log.Fatalf("Migration %d %s failed, it was rolled back (backup of the database: %s): %s", m.version, m.name, backup, err)
+			}
+			log.Fatalf("Migration %d %s failed, it was rolled back: %s", m.version, m.name, err)
+		}
+		fmt.Printf("SUCCESS: Migrated database to schema version %d %s\n", m.version, m.name)
+	}
+}
+
+// Stop, if a database opened read-only has pending migrations
+func checkSchemaVersion(db *DB) {
+	applied := appliedMigrations(db)
+	for _, m := range loadMigrations() {
+		if _, ok := applied[m.version]; !ok {
+			log.Fatalf("Database %s is not at the current schema version (%d %s is pending), migrate it with: cm db migrate -db %s", db.name, m.version, m.name, db.name)
+		}
+	}
+}
+
+// Run one migration and record it in schema_version, all or nothing
+func applyMigration(db *DB, m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if m.apply != nil {
+		err = m.apply(tx)
+	} else {
+		_, err = tx.Exec(m.sql)
+	}
+	if err == nil {
+		_, err = tx.Exec(`INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)`,
+			m.version, m.name, time.Now().Format("2006-01-02 15:04:05"))
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Save a copy of a database which already has tables as <database>.backup-YYYY-MM-DD-hhmmss,
+// return its name. A new or in-memory database has nothing to save.
+func backupDatabase(db *DB) string {
+	var count int
+	db.QueryRow(`SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name != 'schema_version'`).Scan(&count)
+	if count == 0 || db.name == "" || db.name == ":memory:" || strings.Contains(db.name, "mode=memory") {
+		return ""
+	}
+	backup := db.name + ".backup-" + time.Now().Format("2006-01-02-150405")
+	if _, err := db.Exec(`VACUUM INTO ?`, backup); err != nil {
+		log.Fatalf("Cannot save a backup of the database before migrating it to %s: %s", backup, err)
+	}
+	fmt.Println("SUCCESS: Saved a backup of the database before migrating it:", backup)
+	return backup
+}
+
+// Add a column to an existing table, which databases of older versions don't have yet
+func addColumnIfMissing(tx *sql.Tx, table string, column string, definition string) error {
+	row, err := tx.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return err
+	}
+	for row.Next() {
+		var name string
+		row.Scan(&name)
+		if name == column {
+			row.Close()
+			return nil
+		}
+	}
+	row.Close()
+	_, err = tx.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err
+}
+
+// Columns added to cases and matchResults before the schema was versioned: a database
+// of an older cm may have any of them already
+func addLegacyColumns(tx *sql.Tx) error {
+	columns := [][3]string{
+		{"cases", "owner", "text default ''"},
+		{"cases", "notes", "text default ''"},
+		{"cases", "status_changed_at", "text default ''"},
+		{"cases", "auto_resolved_reason", "text default ''"},
+		{"matchResults", "match_score", "real"},
+		{"matchResults", "match_ambiguous", "integer default 0"},
+		{"matchResults", "match_candidates", "text"},
+	}
+	for _, c := range columns {
+		if err := addColumnIfMissing(tx, c[0], c[1], c[2]); err != nil {
+			return fmt.Errorf("add column %s.%s: %w", c[0], c[1], err)
+		}
+	}
+	return nil
+}
+
+// cm db migrate: migrate the database to the current schema version, or with -status only
+// show the migrations applied and pending of an existing database, which is opened read-only
+func dbCommand(args []string, defaultDatabaseName string) {
+	var dbName string
+	var status bool
+	flags := flag.NewFlagSet("db migrate", flag.ExitOnError)
+	flags.StringVar(&dbName, "db", defaultDatabaseName, "Sqlite database to migrate")
+	flags.BoolVar(&status, "status", false, "Only show the applied and pending migrations")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage:")
+		fmt.Fprintln(flags.Output(), "  cm db migrate [-db file] [--status]")
+		flags.PrintDefaults()
+	}
+	if len(args) == 0 || args[0] != "migrate" {
+		flags.Usage()
+		os.Exit(2)
+	}
+	flags.Parse(args[1:])
+
+	var db *DB
+	if status {
+		db = openExistingDatabase(dbName)
+	} else {
+		db = createDatabase(dbName)
+		migrateDatabase(db)
+	}
+	defer db.Close()
+
+	applied := appliedMigrations(db)
+	migrations := loadMigrations()
+	current := 0
+	for _, m := range migrations {
+		if _, ok := applied[m.version]; ok && m.version > current {
+			current = m.version
+		}
+	}
+	fmt.Printf("Database %s is at schema version %d of %d\n", dbName, current, migrations[len(migrations)-1].version)
+	for _, m := range migrations {
+		if applied_at, ok := applied[m.version]; ok {
+			fmt.Printf("  %4d  %-30s applied %s\n", m.version, m.name, applied_at)
+		} else {
+			fmt.Printf("  %4d  %-30s pending\n", m.version, m.name)
+		}
+	}
+}
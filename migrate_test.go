@@ -0,0 +1,154 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// The tables of cm before the schema was versioned, with an account of each source
+const baselineSchema = `
+	CREATE TABLE elevateAccounts (
+		elevate_account_number    text primary key,
+		elevate_mandate_reference text,
+		elevate_customer_name     text
+	);
+	CREATE TABLE crmAccounts (
+		crm_id                text primary key,
+		crm_account_number    text,
+		crm_name              text,
+		crm_email             text,
+		crm_premise_address   text,
+		crm_stage_name        text,
+		crm_gocardless_id     text,
+		crm_zen_user_id       text
+	);
+	CREATE TABLE mandateEvents (
+		id text primary key, created_at text, resource_type text, action text, details_origin text,
+		details_cause text, details_description text, details_scheme text, details_reason_code text,
+		links_previous_customer_bank_account text, links_new_customer_bank_account text, links_parent_event text,
+		links_mandate text, mandates_id text, mandates_created_at text, mandates_reference text, mandates_status text,
+		mandates_scheme text, mandates_next_possible_charge_date text, mandates_payments_require_approval text,
+		mandates_links_customer_bank_account text, mandates_links_creditor text, customers_id text,
+		customers_given_name text, customers_family_name text, customers_company_name text,
+		customers_metadata_leadID text, customers_metadata_link text, customers_metadata_xero text,
+		mandates_metadata_xero text, imported_at text, customers_name text
+	);
+	INSERT INTO elevateAccounts VALUES ('A1', 'MD1', 'Name A1');
+	INSERT INTO crmAccounts VALUES ('C1', 'A1', 'Name A1', '', '', 'ACTIVE', 'CU1', '');
+	INSERT INTO mandateEvents(id, mandates_id, customers_id) VALUES ('EV1', 'MD1', 'CU1');`
+
+// Whether a table of the database has a column
+func hasColumn(t *testing.T, db *DB, table string, column string) bool {
+	t.Helper()
+	var count int
+	if err := db.QueryRow(`SELECT count(*) FROM pragma_table_info(?) WHERE name = ?`, table, column).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	return count > 0
+}
+
+func TestMigrateDatabase(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+	}{
+		{"baseline", baselineSchema},
+		{"some legacy columns", baselineSchema + `
+			CREATE TABLE matchResults (event_id text primary key, match_method integer, match_key text,
+				crm_id text, matched_at text, match_score real);
+			CREATE TABLE cases (case_id integer primary key autoincrement, mandates_id text, customers_id text,
+				opened_at text, status text default 'open', owner text default '', notes text default '');
+			INSERT INTO cases(mandates_id, customers_id, opened_at, owner) VALUES ('MD1', 'CU1', '2026-10-14', 'Anna');`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbName := filepath.Join(t.TempDir(), "cm-old.sqlite3")
+			db := createDatabase(dbName)
+			defer db.Close()
+			if _, err := db.Exec(tt.schema); err != nil {
+				t.Fatal(err)
+			}
+			migrateDatabase(db)
+
+			migrations := loadMigrations()
+			applied := appliedMigrations(db)
+			for _, m := range migrations {
+				if _, ok := applied[m.version]; !ok {
+					t.Errorf("migration %d %s is not applied", m.version, m.name)
+				}
+			}
+			for _, column := range [][2]string{
+				{"cases", "owner"}, {"cases", "notes"}, {"cases", "status_changed_at"}, {"cases", "auto_resolved_reason"},
+				{"matchResults", "match_score"}, {"matchResults", "match_ambiguous"}, {"matchResults", "match_candidates"},
+				{"matchResults", "match_note"}, {"crmAccounts", "crm_deleted_at"}, {"caseRoutings", "crm_stage_at_event"},
+			} {
+				if !hasColumn(t, db, column[0], column[1]) {
+					t.Errorf("column %s.%s is missing", column[0], column[1])
+				}
+			}
+
+			var reference, history string
+			db.QueryRow(`SELECT elevate_mandate_reference FROM elevateMandateReferences WHERE elevate_account_number = 'A1'`).Scan(&reference)
+			db.QueryRow(`SELECT crm_stage_name FROM crmAccountHistory WHERE crm_id = 'C1' AND valid_to = ''`).Scan(&history)
+			if reference != "MD1" || history != "ACTIVE" {
+				t.Errorf("mandate reference %q and CRM history %q of the existing accounts, want MD1 and ACTIVE", reference, history)
+			}
+
+			backups, err := filepath.Glob(dbName + ".backup-*")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(backups) != 1 {
+				t.Fatalf("%d backups, want 1", len(backups))
+			}
+			backup := createDatabase(backups[0])
+			defer backup.Close()
+			if len(appliedMigrations(backup)) != 0 || hasColumn(t, backup, "crmAccounts", "crm_deleted_at") {
+				t.Errorf("backup %s is not the database before the migration", backups[0])
+			}
+
+			// a second run has nothing to migrate and saves no backup
+			migrateDatabase(db)
+			if backups, _ := filepath.Glob(dbName + ".backup-*"); len(backups) != 1 {
+				t.Errorf("%d backups after migrating again, want 1", len(backups))
+			}
+		})
+	}
+}
+
+func TestFailedMigrationIsRolledBack(t *testing.T) {
+	tests := []struct {
+		name      string
+		migration migration
+	}{
+		{"sql", migration{version: 99, name: "broken", sql: `
+			CREATE TABLE broken (a text);
+			INSERT INTO missing VALUES (1);`}},
+		{"go", migration{version: 99, name: "broken", apply: func(tx *sql.Tx) error {
+			if _, err := tx.Exec(`CREATE TABLE broken (a text)`); err != nil {
+				return err
+			}
+			return errors.New("broken")
+		}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDatabase(t)
+			before := len(appliedMigrations(db))
+			if err := applyMigration(db, tt.migration); err == nil {
+				t.Fatal("applyMigration of a broken migration succeeded")
+			}
+			applied := appliedMigrations(db)
+			if _, ok := applied[99]; ok || len(applied) != before {
+				t.Errorf("schema_version has %d migrations, want %d", len(applied), before)
+			}
+			var count int
+			db.QueryRow(`SELECT count(*) FROM sqlite_master WHERE name = 'broken'`).Scan(&count)
+			if count != 0 {
+				t.Error("table broken of the failed migration was not rolled back")
+			}
+		})
+	}
+}
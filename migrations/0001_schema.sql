@@ -0,0 +1,145 @@
+-- Tables and indexes of cm before the schema was versioned. A database created by an
+-- older cm has some or all of them already, so they are only created if missing.
+
+CREATE TABLE IF NOT EXISTS elevateAccounts (
+	elevate_account_number    text primary key,
+	elevate_mandate_reference text,
+	elevate_customer_name     text
+);
+
+CREATE TABLE IF NOT EXISTS mandateEvents (
+	id                                    text primary key,
+	created_at                            text,
+	resource_type                         text,
+	action                                text,
+	details_origin                        text,
+	details_cause                         text,
+	details_description                   text,
+	details_scheme                        text,
+	details_reason_code                   text,
+	links_previous_customer_bank_account  text,
+	links_new_customer_bank_account       text,
+	links_parent_event                    text,
+	links_mandate                         text,
+	mandates_id                           text,
+	mandates_created_at                   text,
+	mandates_reference                    text,
+	mandates_status                       text,
+	mandates_scheme                       text,
+	mandates_next_possible_charge_date    text,
+	mandates_payments_require_approval    text,
+	mandates_links_customer_bank_account  text,
+	mandates_links_creditor               text,
+	customers_id                          text,
+	customers_given_name                  text,
+	customers_family_name                 text,
+	customers_company_name                text,
+	customers_metadata_leadID             text,
+	customers_metadata_link               text,
+	customers_metadata_xero               text,
+	mandates_metadata_xero                text,
+	imported_at                           text,
+	customers_name                        text
+);
+
+CREATE TABLE IF NOT EXISTS crmAccounts (
+	crm_id                text primary key,
+	crm_account_number    text,
+	crm_name              text,
+	crm_email             text,
+	crm_premise_address   text,
+	crm_stage_name        text,
+	crm_gocardless_id     text,
+	crm_zen_user_id       text
+);
+
+-- per mandate event the method that resolved it, the key that matched and the CRM account found.
+-- match_method is the number of the method, not its position: 1 leadID, 2 elevate mandate reference,
+-- 3 gocardless customer id, 4 exact name, 5 normalized name, 6 fuzzy name, 7 manual override, 0 none.
+-- The manual override is tried first, but was added after the others and so got number 7.
+CREATE TABLE IF NOT EXISTS matchResults (
+	event_id      text primary key,
+	match_method  integer,
+	match_key     text,
+	crm_id        text,
+	matched_at    text
+);
+
+-- the CRM account the to-check team assigned by hand to a GoCardless customer or mandate
+CREATE TABLE IF NOT EXISTS matchOverrides (
+	key_type      text,
+	key_value     text,
+	crm_id        text,
+	note          text,
+	created_at    text,
+	primary key (key_type, key_value)
+);
+
+CREATE TABLE IF NOT EXISTS cases (
+	case_id       integer primary key autoincrement,
+	mandates_id   text,
+	customers_id  text,
+	opened_at     text,
+	status        text default 'open'
+);
+
+CREATE TABLE IF NOT EXISTS caseEvents (
+	event_id      text primary key,
+	case_id       integer,
+	follow_up     integer
+);
+
+-- the failed payment requests
+CREATE TABLE IF NOT EXISTS paymentEvents (
+	id                          text primary key,
+	created_at                  text,
+	action                      text,
+	details_cause               text,
+	details_description         text,
+	details_reason_code         text,
+	payments_id                 text,
+	payments_amount             text,
+	payments_charge_date        text,
+	mandates_id                 text,
+	customers_id                text,
+	customers_given_name        text,
+	customers_family_name       text,
+	customers_company_name      text,
+	customers_metadata_leadID   text,
+	imported_at                 text
+);
+
+-- see README "Post Processing Team"
+CREATE TABLE IF NOT EXISTS paymentsSuspended (
+	payments_id                 text primary key,
+	timestamp                   text,
+	payment_requests_count      integer,
+	customers_id                text,
+	customers_given_name        text,
+	customers_family_name       text,
+	customers_metadata_leadID   text,
+	mandates_id                 text
+);
+
+-- the team of every case per processing day, so the team files can be exported again without processing
+CREATE TABLE IF NOT EXISTS caseRoutings (
+	timestamp             text,
+	case_id               integer,
+	event_id              text,
+	case_type             text,
+	target_team           text,
+	routing_rule          text,
+	auto_resolved_reason  text,
+	routed_at             text,
+	primary key (timestamp, case_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_mandate_events_imported_at ON mandateEvents(imported_at);
+CREATE INDEX IF NOT EXISTS idx_crm_accounts_crm_account_number ON crmAccounts(crm_account_number);
+CREATE INDEX IF NOT EXISTS idx_crm_accounts_crm_name ON crmAccounts(crm_name);
+CREATE INDEX IF NOT EXISTS idx_crm_accounts_crm_gocardless_id ON crmAccounts(crm_gocardless_id);
+CREATE INDEX IF NOT EXISTS idx_elevate_accounts_elevate_mandate_reference ON elevateAccounts(elevate_mandate_reference);
+CREATE INDEX IF NOT EXISTS idx_cases_mandates_id_customers_id ON cases(mandates_id, customers_id);
+CREATE INDEX IF NOT EXISTS idx_payment_events_payments_id ON paymentEvents(payments_id);
+CREATE INDEX IF NOT EXISTS idx_payments_suspended_timestamp ON paymentsSuspended(timestamp);
+CREATE INDEX IF NOT EXISTS idx_case_routings_event_id ON caseRoutings(event_id);
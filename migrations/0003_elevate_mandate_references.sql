@@ -0,0 +1,22 @@
+-- Every mandate reference an Elevate account has had. The reference of the latest Elevate
+-- import is current, the others are superseded since the import that replaced them.
+CREATE TABLE IF NOT EXISTS elevateMandateReferences (
+	elevate_account_number    text,
+	elevate_mandate_reference text,
+	first_seen_at             text,
+	last_seen_at              text,
+	superseded_at             text default '',
+	primary key (elevate_account_number, elevate_mandate_reference)
+);
+
+CREATE INDEX IF NOT EXISTS idx_elevate_mandate_references_elevate_mandate_reference
+ON elevateMandateReferences(elevate_mandate_reference);
+
+-- the references imported so far are the current ones, when they were first seen is unknown
+INSERT OR IGNORE INTO elevateMandateReferences(elevate_account_number, elevate_mandate_reference, first_seen_at, last_seen_at, superseded_at)
+SELECT elevate_account_number, elevate_mandate_reference, '', '', ''
+FROM elevateAccounts
+WHERE IFNULL(elevate_mandate_reference, '') != '';
+
+-- why a match needs a closer look, e.g. a superseded mandate reference
+ALTER TABLE matchResults ADD COLUMN match_note text default '';
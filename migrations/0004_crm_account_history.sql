@@ -0,0 +1,28 @@
+-- Every version of a CRM account. A CRM import which changes an account ends its current
+-- version (valid_to) and starts a new one (valid_from), both with the day of the import.
+-- The current version has no valid_to.
+CREATE TABLE IF NOT EXISTS crmAccountHistory (
+	crm_id                text,
+	crm_account_number    text,
+	crm_name              text,
+	crm_email             text,
+	crm_premise_address   text,
+	crm_stage_name        text,
+	crm_gocardless_id     text,
+	crm_zen_user_id       text,
+	valid_from            text,
+	valid_to              text default '',
+	primary key (crm_id, valid_from)
+);
+
+CREATE INDEX IF NOT EXISTS idx_crm_account_history_valid_from ON crmAccountHistory(valid_from);
+
+-- the accounts imported so far are in effect since before the first import
+INSERT OR IGNORE INTO crmAccountHistory(crm_id, crm_account_number, crm_name, crm_email, crm_premise_address,
+	crm_stage_name, crm_gocardless_id, crm_zen_user_id, valid_from, valid_to)
+SELECT crm_id, IFNULL(crm_account_number, ''), IFNULL(crm_name, ''), IFNULL(crm_email, ''), IFNULL(crm_premise_address, ''),
+	IFNULL(crm_stage_name, ''), IFNULL(crm_gocardless_id, ''), IFNULL(crm_zen_user_id, ''), '', ''
+FROM crmAccounts;
+
+-- the CRM stage the account had on the day of the event, which the routing rules saw
+ALTER TABLE caseRoutings ADD COLUMN crm_stage_at_event text default '';
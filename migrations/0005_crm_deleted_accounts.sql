@@ -0,0 +1,19 @@
+-- Every CRM import with the crm_ids it contained. A full import contains all accounts of the
+-- CRM, so the accounts missing from it were deleted, merged or anonymised in the CRM.
+CREATE TABLE IF NOT EXISTS crmImports (
+	import_id     integer primary key autoincrement,
+	file_name     text,
+	imported_for  text,
+	imported_at   text,
+	full_import   integer,
+	account_count integer default 0
+);
+
+CREATE TABLE IF NOT EXISTS crmImportAccounts (
+	import_id     integer,
+	crm_id        text,
+	primary key (import_id, crm_id)
+);
+
+-- the day of the first full import an account was missing from, '' while it is in the CRM
+ALTER TABLE crmAccounts ADD COLUMN crm_deleted_at text default '';
@@ -0,0 +1,20 @@
+-- Every import of an input file: what was imported, by which version of cm, and what became
+-- of its rows. duplicate_of is the first run which imported a file with the same content.
+CREATE TABLE IF NOT EXISTS importRuns (
+	run_id         integer primary key autoincrement,
+	source         text,
+	file_path      text,
+	sha256         text,
+	imported_for   text,
+	started_at     text,
+	duration_ms    integer default 0,
+	rows_read      integer default 0,
+	rows_inserted  integer default 0,
+	rows_updated   integer default 0,
+	rows_skipped   integer default 0,
+	rows_failed    integer default 0,
+	cm_version     text,
+	duplicate_of   integer default 0
+);
+
+CREATE INDEX IF NOT EXISTS idx_import_runs_sha256 ON importRuns(source, sha256);
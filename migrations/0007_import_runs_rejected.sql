@@ -0,0 +1,3 @@
+-- rows which failed the validation of their source and were written to the rejected-rows file
+ALTER TABLE importRuns ADD COLUMN rows_rejected integer default 0;
+ALTER TABLE importRuns ADD COLUMN rejected_file text default '';
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// Query of the override method, prepared by newMatcher
+const SQLGetOverride = `SELECT ` + crmAccountColumns + `
+	FROM matchOverrides
+	INNER JOIN crmAccounts USING (crm_id)
+	WHERE key_type = ? AND key_value = ?`
+
+// Override method: the CRM account assigned by hand to the mandate, or else to the customer
+func (m *matcher) searchOverride(e *mandateEvent) []matchCandidate {
+	for _, key := range [][2]string{{"mandates_id", e.mandates_id}, {"customers_id", e.customers_id}} {
+		if key[1] == "" {
+			continue
+		}
+		row, err := m.override.Query(key[0], key[1])
+		if err != nil {
+			log.Fatalf("Cannot read matchOverrides: %s", err)
+		}
+		var candidates []matchCandidate
+		for row.Next() {
+			account, err := scanCRMAccount(row)
+			if err != nil {
+				log.Fatalf("Cannot read matchOverrides: %s", err)
+			}
+			candidates = addCandidate(candidates, account, 1)
+		}
+		row.Close()
+		if len(candidates) > 0 {
+			return candidates
+		}
+	}
+	return nil
+}
+
+// Assign a CRM account to a customers_id or mandates_id, replacing an earlier override
+func addMatchOverride(db *DB, keyType string, keyValue string, crmId string, note string) error {
+	if keyType != "customers_id" && keyType != "mandates_id" {
+		return fmt.Errorf("override key must be customers_id or mandates_id, not %q", keyType)
+	}
+	if keyValue == "" || crmId == "" {
+		return errors.New("override needs a customers_id or mandates_id and a crm_id")
+	}
+
+	var count int
+	db.QueryRow(`SELECT count(*) FROM crmAccounts WHERE crm_id = ?`, crmId).Scan(&count)
+	if count == 0 {
+		fmt.Println("WARNING: crm_id", crmId, "is not in table crmAccounts (yet), the override is used as soon as it is imported")
+	}
+
+	SQLAddOverride := `
+		INSERT INTO matchOverrides(key_type, key_value, crm_id, note, created_at)
+		values(?, ?, ?, ?, ?)
+		ON CONFLICT(key_type, key_value)
+		DO UPDATE SET
+		    crm_id=excluded.crm_id,
+		    note=excluded.note,
+		    created_at=excluded.created_at
+	`
+	_, err := db.Exec(SQLAddOverride, keyType, keyValue, crmId, note, time.Now().Format("2006-01-02 15:04:05"))
+	return err
+}
+
+// Remove the override of a customers_id or mandates_id
+func removeMatchOverride(db *DB, keyType string, keyValue string) error {
+	result, err := db.Exec(`DELETE FROM matchOverrides WHERE key_type = ? AND key_value = ?`, keyType, keyValue)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("there is no override for %s %s", keyType, keyValue)
+	}
+	return nil
+}
+
+// Print all overrides
+func listMatchOverrides(db *DB) {
+	row, err := db.Query(`SELECT key_type, key_value, crm_id, note, created_at FROM matchOverrides ORDER BY key_type, key_value`)
+	if err != nil {
+		log.Fatalf("Cannot read matchOverrides: %s", err)
+	}
+	defer row.Close()
+	fmt.Printf("%-14s %-20s %-20s %-20s %s\n", "key_type", "key_value", "crm_id", "created_at", "note")
+	for row.Next() {
+		var keyType, keyValue, crmId, note, createdAt string
+		row.Scan(&keyType, &keyValue, &crmId, &note, &createdAt)
+		fmt.Printf("%-14s %-20s %-20s %-20s %s\n", keyType, keyValue, crmId, createdAt, note)
+	}
+}
+
+// Import overrides from a CSV file filled in by the to-check team, with the
+// columns customers_id or mandates_id, crm_id and an optional note
+func importMatchOverrides(db *DB, csvFileName string) {
+	fileData, err := os.Open(csvFileName)
+	if err != nil {
+		log.Fatalf("Cannot open overrides file: %s %s", csvFileName, err)
+	}
+	defer fileData.Close()
+
+	recordData := readCSVFile(fileData, csvFileName, inputFormat{})
+	columns := readHeader("overrides", csvFileName, recordData)
+
+	for {
+		record, err := recordData.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			fmt.Println("ERROR:   Cannot read record from", csvFileName, err)
+			continue
+		}
+
+		crm_id := columns.get(record, "crm_id")
+		note := columns.get(record, "note")
+		keyType, keyValue := "mandates_id", columns.get(record, "mandates_id")
+		if keyValue == "" {
+			keyType, keyValue = "customers_id", columns.get(record, "customers_id")
+		}
+		if keyValue == "" && crm_id == "" {
+			continue
+		}
+
+		if err = addMatchOverride(db, keyType, keyValue, crm_id, note); err != nil {
+			fmt.Println("ERROR:   Insert into table matchOverrides failed for", keyType, keyValue, err)
+		} else {
+			fmt.Println("SUCCESS: Insert into table matchOverrides with", keyType, keyValue, "crm_id:", crm_id)
+		}
+	}
+}
+
+// cm override add|list|remove|import: maintain the manual match overrides
+func overrideCommand(args []string, defaultDatabaseName string) {
+	var dbName, customerId, mandateId, crmId, note string
+	flags := flag.NewFlagSet("override", flag.ExitOnError)
+	flags.StringVar(&dbName, "db", defaultDatabaseName, "Sqlite database to use")
+	flags.StringVar(&customerId, "customer", "", "GoCardless customers_id (CU...) to assign")
+	flags.StringVar(&mandateId, "mandate", "", "GoCardless mandates_id (MD...) to assign")
+	flags.StringVar(&crmId, "crm", "", "CRM C0 ID of the account to assign")
+	flags.StringVar(&note, "note", "", "Why this account was assigned")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage:")
+		fmt.Fprintln(flags.Output(), "  cm override add    (-customer CU... | -mandate MD...) -crm <crm_id> [-note text]")
+		fmt.Fprintln(flags.Output(), "  cm override remove (-customer CU... | -mandate MD...)")
+		fmt.Fprintln(flags.Output(), "  cm override list")
+		fmt.Fprintln(flags.Output(), "  cm override import <file.csv>   with columns customers_id or mandates_id, crm_id, note")
+		flags.PrintDefaults()
+	}
+	if len(args) == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	action := args[0]
+	flags.Parse(args[1:])
+
+	keyType, keyValue := "customers_id", customerId
+	if mandateId != "" {
+		keyType, keyValue = "mandates_id", mandateId
+	}
+
+	db := createDatabase(dbName)
+	defer db.Close()
+	createSchema(db)
+
+	switch action {
+	case "add":
+		if err := addMatchOverride(db, keyType, keyValue, crmId, note); err != nil {
+			log.Fatalf("Cannot add override: %s", err)
+		}
+		fmt.Println("SUCCESS: Override", keyType, keyValue, "-> crm_id", crmId)
+	case "remove":
+		if err := removeMatchOverride(db, keyType, keyValue); err != nil {
+			log.Fatalf("Cannot remove override: %s", err)
+		}
+		fmt.Println("SUCCESS: Removed override", keyType, keyValue)
+	case "list":
+		listMatchOverrides(db)
+	case "import":
+		if flags.NArg() != 1 {
+			flags.Usage()
+			os.Exit(2)
+		}
+		importMatchOverrides(db, flags.Arg(0))
+	default:
+		flags.Usage()
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import "strings"
+
+// What a GoCardless cause or scheme reason code means for the teams
+type reasonInfo struct {
+	category         string
+	severity         string
+	explanation      string
+	suggested_action string
+}
+
+// Categories of the reasons, usable as reason_category in the routing rules
+var reasonCategories = []string{
+	"bank account", "customer cancelled", "dispute", "insufficient funds",
+	"deceased", "expired", "creditor", "administrative", "healthy", "unknown",
+}
+
+// Catalogue of the GoCardless causes (details.cause), see https://developer.gocardless.com/api-reference/#events-reason-codes
+var causeCatalogue = map[string]reasonInfo{
+	"bank_account_closed": {"bank account", "high",
+		"The customer's bank account was closed",
+		"Ask the customer for new bank details and set up a new mandate"},
+	"bank_account_transferred": {"bank account", "medium",
+		"The customer moved the mandate to a different bank account",
+		"Check that a new mandate or bank account is in place, else ask for new bank details"},
+	"invalid_bank_details": {"bank account", "high",
+		"The bank details of the mandate are invalid",
+		"Ask the customer to verify the bank details and set up a new mandate"},
+	"direct_debit_not_enabled": {"bank account", "high",
+		"The customer's bank account does not accept Direct Debits",
+		"Ask the customer for a bank account accepting Direct Debits, or another way to pay"},
+	"account_blocked_for_any_financial_transaction": {"bank account", "high",
+		"The customer's bank account is blocked",
+		"Contact the customer for another bank account or way to pay"},
+	"bank_account_disabled": {"bank account", "high",
+		"The bank account was disabled in GoCardless",
+		"Ask the customer for new bank details"},
+	"authorisation_disputed": {"dispute", "high",
+		"The customer says they never authorised the Direct Debit",
+		"Check the sign-up with the customer before any further collection, escalate if unclear"},
+	"mandate_cancelled": {"customer cancelled", "high",
+		"The mandate was cancelled by the customer or their bank",
+		"Contact the customer to find out why and set up a new mandate"},
+	"mandate_suspended_by_payer": {"customer cancelled", "medium",
+		"The customer suspended the mandate",
+		"Contact the customer before collecting further payments"},
+	"refer_to_payer": {"insufficient funds", "medium",
+		"The bank refused the payment, usually for insufficient funds",
+		"Contact the customer and retry the payment once funds are available"},
+	"insufficient_funds": {"insufficient funds", "medium",
+		"There were not enough funds on the customer's bank account",
+		"Contact the customer and retry the payment once funds are available"},
+	"payer_death": {"deceased", "high",
+		"The customer has died",
+		"Stop all collections and hand over to the team dealing with bereavements"},
+	"mandate_expired": {"expired", "medium",
+		"The mandate was not used for a long time and expired",
+		"Ask the customer to set up a new mandate"},
+	"return_on_odd": {"administrative", "low",
+		"The bank returned the instruction on an 'other delivery date'",
+		"Check the mandate status in GoCardless, usually no action is needed"},
+	"scheme_identifier_changed": {"creditor", "low",
+		"Our scheme identifier changed",
+		"No action - the mandate is moved by GoCardless"},
+	"mandate_replaced": {"bank account", "low",
+		"The mandate was replaced by a new one",
+		"No action - check that the new mandate is active"},
+	"customer_approval_denied": {"customer cancelled", "high",
+		"The customer did not approve the mandate",
+		"Contact the customer to complete the sign-up"},
+	"mandate_created":   {"healthy", "low", "The mandate was created", "No action"},
+	"mandate_submitted": {"healthy", "low", "The mandate was submitted to the bank", "No action"},
+	"mandate_activated": {"healthy", "low", "The mandate is active", "No action"},
+	"mandate_reinstated": {"healthy", "low",
+		"The mandate was reinstated after a cancellation or failure",
+		"No action - payments can be collected again"},
+}
+
+// Catalogue of the scheme reason codes (details.reason_code) of Bacs (ADDACS, AUDDIS, ARUDD),
+// SEPA and Autogiro. Autogiro codes are keyed with the scheme, as they are plain numbers.
+var reasonCodeCatalogue = map[string]reasonInfo{
+	"ADDACS-0":    {"customer cancelled", "high", "Bacs: the Direct Debit instruction was cancelled by the payer", "Contact the customer to find out why and set up a new mandate"},
+	"ADDACS-1":    {"customer cancelled", "high", "Bacs: the instruction was cancelled, refer to payer", "Contact the customer to find out why and set up a new mandate"},
+	"ADDACS-2":    {"deceased", "high", "Bacs: the payer has died", "Stop all collections and hand over to the team dealing with bereavements"},
+	"ADDACS-3":    {"bank account", "medium", "Bacs: the account was transferred to a new bank", "Check that the mandate was moved, else ask for new bank details"},
+	"ADDACS-B":    {"bank account", "high", "Bacs: the account was closed", "Ask the customer for new bank details and set up a new mandate"},
+	"ADDACS-C":    {"bank account", "medium", "Bacs: the account was transferred to a new bank", "Check that the mandate was moved, else ask for new bank details"},
+	"ADDACS-D":    {"dispute", "high", "Bacs: the payer disputed the advance notice", "Check the amounts and dates notified with the customer"},
+	"ADDACS-E":    {"administrative", "low", "Bacs: the instruction was amended", "Check the mandate in GoCardless, usually no action is needed"},
+	"ADDACS-R":    {"healthy", "low", "Bacs: the instruction was reinstated", "No action - payments can be collected again"},
+	"AUDDIS-1":    {"customer cancelled", "high", "Bacs: the instruction was cancelled by the payer", "Contact the customer to find out why and set up a new mandate"},
+	"AUDDIS-2":    {"deceased", "high", "Bacs: the payer has died", "Stop all collections and hand over to the team dealing with bereavements"},
+	"AUDDIS-3":    {"bank account", "medium", "Bacs: the account was transferred", "Ask the customer for the new bank details"},
+	"AUDDIS-5":    {"bank account", "high", "Bacs: there is no such account", "Ask the customer to verify the bank details and set up a new mandate"},
+	"AUDDIS-6":    {"administrative", "medium", "Bacs: there is no instruction for this account", "Set up the mandate again"},
+	"AUDDIS-B":    {"bank account", "high", "Bacs: the account was closed", "Ask the customer for new bank details and set up a new mandate"},
+	"AUDDIS-C":    {"bank account", "medium", "Bacs: the account was transferred to a new bank", "Ask the customer for the new bank details"},
+	"AUDDIS-F":    {"bank account", "high", "Bacs: the account type does not accept Direct Debits", "Ask the customer for a bank account accepting Direct Debits"},
+	"AUDDIS-G":    {"bank account", "high", "Bacs: the bank does not accept Direct Debits on this account", "Ask the customer for a bank account accepting Direct Debits"},
+	"AUDDIS-H":    {"expired", "medium", "Bacs: the instruction has expired", "Ask the customer to set up a new mandate"},
+	"AUDDIS-I":    {"administrative", "medium", "Bacs: the payer reference is not unique", "Set up the mandate again with a unique reference"},
+	"AUDDIS-K":    {"customer cancelled", "high", "Bacs: the instruction was cancelled by the paying bank", "Contact the customer to find out why and set up a new mandate"},
+	"AUDDIS-L":    {"bank account", "high", "Bacs: the payer's account details are incorrect", "Ask the customer to verify the bank details and set up a new mandate"},
+	"AUDDIS-M":    {"bank account", "medium", "Bacs: the account is being transferred to a new bank", "Check that the mandate was moved, else ask for new bank details"},
+	"AUDDIS-N":    {"bank account", "low", "Bacs: the account is being transferred within the same bank", "No action - the mandate is moved by the bank"},
+	"ARUDD-0":     {"insufficient funds", "medium", "Bacs: refer to payer, usually not enough funds", "Contact the customer and retry the payment once funds are available"},
+	"ARUDD-1":     {"customer cancelled", "high", "Bacs: the instruction was cancelled by the payer", "Contact the customer to find out why and set up a new mandate"},
+	"ARUDD-2":     {"deceased", "high", "Bacs: the payer has died", "Stop all collections and hand over to the team dealing with bereavements"},
+	"ARUDD-3":     {"bank account", "medium", "Bacs: the account was transferred", "Ask the customer for the new bank details"},
+	"ARUDD-4":     {"dispute", "high", "Bacs: the payer disputed the advance notice", "Check the amounts and dates notified with the customer"},
+	"ARUDD-5":     {"bank account", "high", "Bacs: there is no such account", "Ask the customer to verify the bank details and set up a new mandate"},
+	"ARUDD-6":     {"administrative", "medium", "Bacs: there is no instruction for this account", "Set up the mandate again"},
+	"ARUDD-B":     {"bank account", "high", "Bacs: the account was closed", "Ask the customer for new bank details and set up a new mandate"},
+	"AC01":        {"bank account", "high", "SEPA: the account identifier (IBAN) is incorrect", "Ask the customer to verify the IBAN and set up a new mandate"},
+	"AC04":        {"bank account", "high", "SEPA: the account was closed", "Ask the customer for new bank details and set up a new mandate"},
+	"AC06":        {"bank account", "high", "SEPA: the account is blocked", "Contact the customer for another bank account or way to pay"},
+	"AC13":        {"bank account", "high", "SEPA: the account type does not accept Direct Debits", "Ask the customer for a bank account accepting Direct Debits"},
+	"AG01":        {"bank account", "high", "SEPA: Direct Debits are forbidden on this account", "Ask the customer for a bank account accepting Direct Debits"},
+	"AM04":        {"insufficient funds", "medium", "SEPA: there were not enough funds", "Contact the customer and retry the payment once funds are available"},
+	"MD01":        {"dispute", "high", "SEPA: there is no valid mandate", "Check the sign-up with the customer and set up a new mandate"},
+	"MD06":        {"dispute", "high", "SEPA: the customer asked for a refund", "Contact the customer to find out why"},
+	"MD07":        {"deceased", "high", "SEPA: the customer has died", "Stop all collections and hand over to the team dealing with bereavements"},
+	"MS02":        {"customer cancelled", "high", "SEPA: the customer refused the Direct Debit", "Contact the customer to find out why"},
+	"MS03":        {"unknown", "medium", "SEPA: the bank gave no reason", "Contact the customer to find out why"},
+	"SL01":        {"customer cancelled", "high", "SEPA: the customer's bank blocked Direct Debits from us", "Contact the customer to lift the block or set up a new mandate"},
+	"autogiro 02": {"customer cancelled", "high", "Autogiro: the mandate was cancelled by the payer or their bank", "Contact the customer to find out why and set up a new mandate"},
+	"autogiro 03": {"bank account", "high", "Autogiro: the account was closed", "Ask the customer for new bank details and set up a new mandate"},
+	"autogiro 04": {"bank account", "high", "Autogiro: the account does not accept Autogiro", "Ask the customer for a bank account accepting Autogiro"},
+}
+
+// Classify the reason of an event: by its scheme reason code if known, else by its cause
+func classifyReason(e *mandateEvent) reasonInfo {
+	scheme := e.details_scheme
+	if scheme == "" {
+		scheme = e.mandates_scheme
+	}
+	code := strings.ToUpper(strings.TrimSpace(e.details_reason_code))
+	if code != "" {
+		if info, ok := reasonCodeCatalogue[strings.ToLower(scheme)+" "+code]; ok {
+			return info
+		}
+		if info, ok := reasonCodeCatalogue[code]; ok {
+			return info
+		}
+	}
+	cause := strings.ToLower(strings.TrimSpace(e.details_cause))
+	if cause == "mandate_cancelled" && e.details_origin == "api" {
+		return reasonInfo{"creditor", "low", "The mandate was cancelled at our request", "No action"}
+	}
+	if info, ok := causeCatalogue[cause]; ok {
+		return info
+	}
+	return reasonInfo{"unknown", "medium",
+		"The reason is not in the catalogue: " + strings.TrimSpace(e.details_cause+" "+e.details_reason_code),
+		"Check the event in GoCardless"}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Team and routing rule of the cases cm resolved itself
+const autoResolvedTeam = "Auto-resolved"
+
+// Mandate event actions after which a failed or cancelled mandate collects payments again
+const reinstatingActions = `'reinstated', 'active'`
+
+// Mandate event actions moving a mandate to a new bank account
+const replacingActions = `'replaced', 'transferred'`
+
+// Mandate statuses of a mandate which doesn't collect payments
+const inactiveMandateStatuses = `'cancelled', 'failed', 'expired', 'blocked', 'consumed'`
+
+// Is the action one of a mandate being set up or collecting payments again, which no team has to follow up?
+func isHealthyAction(action string) bool {
+	switch action {
+	case "created", "customer_approval_granted", "submitted", "active", "reinstated":
+		return true
+	}
+	return false
+}
+
+// Why a case doesn't need a team anymore, empty if it still does:
+// the case has no failure at all, the mandate was reinstated or became active again after its last failure,
+// it was moved to a new bank account, or the customer has a newer mandate which
+// isn't cancelled or failed.
+func autoResolveReason(db *DB, c *mandateCase, timestamp string) string {
+	// the last failure of the case, a case of healthy events only has none
+	var failure *mandateEvent
+	for _, e := range c.events {
+		if !isHealthyAction(e.action) {
+			failure = e
+		}
+	}
+	if failure == nil {
+		e := c.latestEvent()
+		return fmt.Sprintf("mandate %s %s on %s (%s)", e.mandates_id, e.action, e.created_at, e.id)
+	}
+
+	var id, action, created_at, bank_account, mandates_id, mandates_status string
+
+	SQLGetReinstated := `
+		SELECT id, action, created_at
+		FROM mandateEvents
+		WHERE mandates_id = ? AND action IN (` + reinstatingActions + `)
+		  AND created_at > ? AND imported_at <= ?
+		ORDER BY created_at DESC
+		LIMIT 1`
+	err := db.QueryRow(SQLGetReinstated, failure.mandates_id, failure.created_at, timestamp).Scan(&id, &action, &created_at)
+	if err == nil {
+		return fmt.Sprintf("mandate %s %s on %s (%s)", failure.mandates_id, action, created_at, id)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		log.Fatalf("Cannot read reinstated events of mandate %s: %s", failure.mandates_id, err)
+	}
+
+	SQLGetReplaced := `
+		SELECT id, action, links_new_customer_bank_account
+		FROM mandateEvents
+		WHERE mandates_id = ? AND created_at > ? AND imported_at <= ?
+		  AND (action IN (` + replacingActions + `) OR links_new_customer_bank_account != '')
+		ORDER BY created_at DESC
+		LIMIT 1`
+	err = db.QueryRow(SQLGetReplaced, failure.mandates_id, failure.created_at, timestamp).Scan(&id, &action, &bank_account)
+	if err == nil {
+		if bank_account != "" {
+			return fmt.Sprintf("mandate %s %s to new bank account %s (%s)", failure.mandates_id, action, bank_account, id)
+		}
+		return fmt.Sprintf("mandate %s %s (%s)", failure.mandates_id, action, id)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		log.Fatalf("Cannot read replacing events of mandate %s: %s", failure.mandates_id, err)
+	}
+
+	// mandates_created_at is an RFC 3339 time or a date, so the days are compared:
+	// a mandate created on the day of the failed one or later replaces it
+	SQLGetReplacementMandate := `
+		SELECT mandates_id, mandates_status, mandates_created_at
+		FROM mandateEvents
+		WHERE customers_id = ?1 AND mandates_id != ?2 AND customers_id != ''
+		  AND (?3 = '' OR date(mandates_created_at) >= ?3) AND imported_at <= ?4
+		  AND mandates_status NOT IN (` + inactiveMandateStatuses + `)
+		ORDER BY date(mandates_created_at) DESC, mandates_created_at DESC
+		LIMIT 1`
+	err = db.QueryRow(SQLGetReplacementMandate, failure.customers_id, failure.mandates_id, mandateDay(failure.mandates_created_at), timestamp).Scan(&mandates_id, &mandates_status, &created_at)
+	if err == nil {
+		return fmt.Sprintf("replaced by mandate %s (%s) created on %s", mandates_id, mandates_status, created_at)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		log.Fatalf("Cannot read replacement mandates of customer %s: %s", failure.customers_id, err)
+	}
+	return ""
+}
+
+// The day (YYYY-MM-DD, UTC) of a mandates_created_at given as RFC 3339 time or as date,
+// "" if it is neither
+func mandateDay(created_at string) string {
+	if t, err := time.Parse(time.RFC3339, created_at); err == nil {
+		return t.UTC().Format("2006-01-02")
+	}
+	if t, err := time.Parse("2006-01-02", created_at); err == nil {
+		return t.Format("2006-01-02")
+	}
+	return ""
+}
+
+// Close a case cm resolved itself, with the reason as note
+func autoResolveCase(db *DB, c *mandateCase, reason string) {
+	SQLResolveCase := `
+		UPDATE cases SET
+		    status='resolved',
+		    owner='cm',
+		    notes=?,
+		    auto_resolved_reason=?,
+		    status_changed_at=?
+		WHERE case_id = ?`
+	_, err := db.Exec(SQLResolveCase, "auto-resolved: "+reason, reason, time.Now().Format("2006-01-02 15:04:05"), c.case_id)
+	if err != nil {
+		log.Fatalf("Update of table cases failed for case %d: %s", c.case_id, err)
+	}
+	c.status, c.owner, c.notes = "resolved", "cm", "auto-resolved: "+reason
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestMandateDay(t *testing.T) {
+	tests := []struct {
+		created_at, want string
+	}{
+		{"2026-10-15T08:00:00Z", "2026-10-15"},
+		{"2026-10-15T08:00:00.000Z", "2026-10-15"},
+		{"2026-10-15T23:30:00-02:00", "2026-10-16"},
+		{"2026-10-15", "2026-10-15"},
+		{"", ""},
+		{"15.10.2026", ""},
+	}
+	for _, tt := range tests {
+		if got := mandateDay(tt.created_at); got != tt.want {
+			t.Errorf("mandateDay(%q) = %q, want %q", tt.created_at, got, tt.want)
+		}
+	}
+}
+
+func TestAutoResolveReason(t *testing.T) {
+	db := newTestDatabase(t)
+	insertTestEvents(t, db,
+		// MD6 was transferred before it was cancelled, MD8 after it failed
+		map[string]string{"id": "EV10", "created_at": "2026-10-01T08:00:00Z", "action": "transferred", "mandates_id": "MD6",
+			"links_new_customer_bank_account": "BA9", "customers_id": "CU6", "imported_at": "2026-10-01"},
+		map[string]string{"id": "EV20", "created_at": "2026-10-17T08:00:00Z", "action": "transferred", "mandates_id": "MD8",
+			"links_new_customer_bank_account": "BA8", "customers_id": "CU8", "imported_at": "2026-10-17"},
+		// customer CU7 has an older and a newer active mandate than MD7, given as date and as RFC 3339 time
+		map[string]string{"id": "EV30", "created_at": "2026-10-02T08:00:00Z", "action": "created", "mandates_id": "MD70",
+			"mandates_created_at": "2025-12-01", "mandates_status": "active", "customers_id": "CU7", "imported_at": "2026-10-02"},
+		map[string]string{"id": "EV31", "created_at": "2026-10-16T08:00:00Z", "action": "created", "mandates_id": "MD71",
+			"mandates_created_at": "2026-10-16", "mandates_status": "active", "customers_id": "CU7", "imported_at": "2026-10-16"},
+		// customer CU9 only has an older active mandate
+		map[string]string{"id": "EV40", "created_at": "2026-10-02T08:00:00Z", "action": "created", "mandates_id": "MD90",
+			"mandates_created_at": "2025-12-01", "mandates_status": "active", "customers_id": "CU9", "imported_at": "2026-10-02"},
+	)
+
+	tests := []struct {
+		name    string
+		failure mandateEvent
+		day     string
+		want    string
+	}{
+		{"transfer before the cancellation", mandateEvent{id: "EV11", created_at: "2026-10-15T08:00:00Z", action: "cancelled",
+			mandates_id: "MD6", customers_id: "CU6", mandates_created_at: "2026-01-01T08:00:00Z"}, "2026-10-17", ""},
+		{"transfer after the failure", mandateEvent{id: "EV21", created_at: "2026-10-15T08:00:00Z", action: "failed",
+			mandates_id: "MD8", customers_id: "CU8", mandates_created_at: "2026-01-01T08:00:00Z"}, "2026-10-17",
+			"mandate MD8 transferred to new bank account BA8 (EV20)"},
+		{"transfer imported after the day", mandateEvent{id: "EV21", created_at: "2026-10-15T08:00:00Z", action: "failed",
+			mandates_id: "MD8", customers_id: "CU8", mandates_created_at: "2026-01-01T08:00:00Z"}, "2026-10-16", ""},
+		{"newer mandate given as date", mandateEvent{id: "EV32", created_at: "2026-10-15T08:00:00Z", action: "cancelled",
+			mandates_id: "MD7", customers_id: "CU7", mandates_created_at: "2026-01-01T08:00:00Z"}, "2026-10-16",
+			"replaced by mandate MD71 (active) created on 2026-10-16"},
+		{"older mandate only", mandateEvent{id: "EV41", created_at: "2026-10-15T08:00:00Z", action: "cancelled",
+			mandates_id: "MD9", customers_id: "CU9", mandates_created_at: "2026-01-01T08:00:00Z"}, "2026-10-16", ""},
+		{"failed mandate given as date", mandateEvent{id: "EV42", created_at: "2026-10-15T08:00:00Z", action: "cancelled",
+			mandates_id: "MD9", customers_id: "CU9", mandates_created_at: "2025-11-30"}, "2026-10-16",
+			"replaced by mandate MD90 (active) created on 2025-12-01"},
+	}
+	for _, tt := range tests {
+		failure := tt.failure
+		c := &mandateCase{events: []*mandateEvent{&failure}}
+		if got := autoResolveReason(db, c, tt.day); got != tt.want {
+			t.Errorf("%s: autoResolveReason = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// A team receiving mandate cases, with the file its cases are exported to.
+// {date} in the file name is replaced by the processing day. Teams without
+// a file of their own are exported to the to-check file.
+type routingTeam struct {
+	Name string `json:"name"`
+	File string `json:"file"`
+}
+
+// A routing rule: all conditions given must hold for the rule to pick its team.
+// A list condition holds if the value is one of the list (case-insensitive),
+// details_description is a regular expression. reason_category is the category
+// of the cause or reason code in the reason catalogue.
+type routingRule struct {
+	Name               string   `json:"name"`
+	CRMStageName       []string `json:"crm_stage_name"`
+	DetailsCause       []string `json:"details_cause"`
+	DetailsReasonCode  []string `json:"details_reason_code"`
+	DetailsDescription string   `json:"details_description"`
+	Action             []string `json:"action"`
+	Scheme             []string `json:"scheme"`
+	ReasonCategory     []string `json:"reason_category"`
+	MatchMethod        []string `json:"match_method"`
+	Ambiguous          *bool    `json:"ambiguous"`
+	CRMDeleted         *bool    `json:"crm_deleted"`
+	Team               string   `json:"team"`
+	descriptionPattern *regexp.Regexp
+}
+
+// Teams and ordered rules deciding which team processes a mandate case
+type routingConfig struct {
+	Teams       []routingTeam `json:"teams"`
+	Rules       []routingRule `json:"rules"`
+	DefaultTeam string        `json:"default_team"`
+}
+
+// The routing used without -rules, see README "Assign Team"
+const defaultRoutingRules = `{
+  "teams": [
+    { "name": "Pre-Installation",           "file": "mandates-to-process-by-pre-installation-team-{date}.csv" },
+    { "name": "Post-Installation",          "file": "mandates-to-process-by-post-installation-team-{date}.csv" },
+    { "name": "No action - Inactive" },
+    { "name": "No action - at our request" },
+    { "name": "To check - ambiguous match" },
+    { "name": "To check - deleted CRM account" }
+  ],
+  "rules": [
+    { "name": "at our request",    "details_description": "at your request",                  "team": "No action - at our request" },
+    { "name": "ambiguous match",   "ambiguous": true,                                         "team": "To check - ambiguous match" },
+    { "name": "deleted account",   "crm_deleted": true,                                       "team": "To check - deleted CRM account" },
+    { "name": "inactive",          "crm_stage_name": ["INACTIVE"],                            "team": "No action - Inactive" },
+    { "name": "post installation", "crm_stage_name": ["PROVISIONING", "INVOICING", "ACTIVE"], "team": "Post-Installation" },
+    { "name": "pre installation",  "crm_stage_name": ["N/A", "SOLD", "INSTALL"],              "team": "Pre-Installation" }
+  ],
+  "default_team": "Pre-Installation"
+}`
+
+// Load the routing rules from a JSON file, or the default rules if no file is given
+func loadRoutingRules(fileName string) *routingConfig {
+	data := []byte(defaultRoutingRules)
+	if fileName != "" {
+		var err error
+		if data, err = os.ReadFile(fileName); err != nil {
+			log.Fatalf("Cannot read rules file: %s %s", fileName, err)
+		}
+	} else {
+		fileName = "default rules"
+	}
+
+	var routing routingConfig
+	if err := json.Unmarshal(data, &routing); err != nil {
+		log.Fatalf("Cannot parse rules file: %s %s", fileName, err)
+	}
+	if err := routing.check(); err != nil {
+		log.Fatalf("Invalid rules file: %s %s", fileName, err)
+	}
+	return &routing
+}
+
+// Check that every rule and the default refer to a known team, and compile the patterns
+func (routing *routingConfig) check() error {
+	teams := make(map[string]bool)
+	for _, team := range routing.Teams {
+		if team.Name == "" {
+			return fmt.Errorf("a team has no name")
+		}
+		if team.Name == autoResolvedTeam {
+			return fmt.Errorf("team %q is reserved for the cases cm resolves itself", team.Name)
+		}
+		teams[team.Name] = true
+	}
+	if !teams[routing.DefaultTeam] {
+		return fmt.Errorf("default_team %q is not one of the teams", routing.DefaultTeam)
+	}
+	for i := range routing.Rules {
+		rule := &routing.Rules[i]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		if !teams[rule.Team] {
+			return fmt.Errorf("rule %q: team %q is not one of the teams", rule.Name, rule.Team)
+		}
+		for _, category := range rule.ReasonCategory {
+			if !conditionHolds(reasonCategories, category) {
+				return fmt.Errorf("rule %q: reason_category %q is not one of %s", rule.Name, category, strings.Join(reasonCategories, ", "))
+			}
+		}
+		if rule.DetailsDescription != "" {
+			pattern, err := regexp.Compile(rule.DetailsDescription)
+			if err != nil {
+				return fmt.Errorf("rule %q: details_description: %s", rule.Name, err)
+			}
+			rule.descriptionPattern = pattern
+		}
+	}
+	return nil
+}
+
+// A list condition holds if it is empty or contains the value
+func conditionHolds(accepted []string, value string) bool {
+	if len(accepted) == 0 {
+		return true
+	}
+	for _, a := range accepted {
+		if strings.EqualFold(strings.TrimSpace(a), strings.TrimSpace(value)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Does the rule apply to the event, its CRM account and the way the account was found?
+func (rule *routingRule) applies(e *mandateEvent, match matchResult) bool {
+	scheme := e.details_scheme
+	if scheme == "" {
+		scheme = e.mandates_scheme
+	}
+	return conditionHolds(rule.CRMStageName, match.account.crm_stage_name) &&
+		conditionHolds(rule.DetailsCause, e.details_cause) &&
+		conditionHolds(rule.DetailsReasonCode, e.details_reason_code) &&
+		conditionHolds(rule.Action, e.action) &&
+		conditionHolds(rule.Scheme, scheme) &&
+		conditionHolds(rule.ReasonCategory, classifyReason(e).category) &&
+		conditionHolds(rule.MatchMethod, matchMethodName(match.method)) &&
+		(rule.Ambiguous == nil || *rule.Ambiguous == match.ambiguous) &&
+		(rule.CRMDeleted == nil || *rule.CRMDeleted == (match.deleted_at != "")) &&
+		(rule.descriptionPattern == nil || rule.descriptionPattern.MatchString(e.details_description))
+}
+
+// The team of the first rule applying to the event, or the default team.
+// Also returns the name of that rule, "default" if none applied.
+func (routing *routingConfig) route(e *mandateEvent, match matchResult) (string, string) {
+	for i := range routing.Rules {
+		if routing.Rules[i].applies(e, match) {
+			return routing.Rules[i].Team, routing.Rules[i].Name
+		}
+	}
+	return routing.DefaultTeam, "default"
+}
+
+// Export file per team for a processing day. Teams without a file of their own
+// get the to-check file, -toPre and -toPost replace the files of the default teams.
+// The cases cm resolved itself get the auto-resolved file.
+func (routing *routingConfig) teamFiles(current_path string, timestamp string, files dailyFiles) map[string]string {
+	teamFiles := make(map[string]string)
+	for _, team := range routing.Teams {
+		fileName := files.csvOtherTeamTo
+		if team.File != "" {
+			fileName = strings.ReplaceAll(team.File, "{date}", timestamp)
+			if !filepath.IsAbs(fileName) {
+				fileName = filepath.Join(current_path, fileName)
+			}
+		}
+		teamFiles[team.Name] = fileName
+	}
+	if files.csvPreTeamTo != "" {
+		teamFiles["Pre-Installation"] = files.csvPreTeamTo
+	}
+	if files.csvPostTeamTo != "" {
+		teamFiles["Post-Installation"] = files.csvPostTeamTo
+	}
+	teamFiles[autoResolvedTeam] = files.csvAutoResolvedTo
+	return teamFiles
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRoute(t *testing.T) {
+	routing := loadRoutingRules("")
+	tests := []struct {
+		name       string
+		event      mandateEvent
+		match      matchResult
+		team, rule string
+	}{
+		{"active account",
+			mandateEvent{details_cause: "bank_account_closed"},
+			matchResult{method: 1, account: crmAccount{crm_stage_name: "ACTIVE"}},
+			"Post-Installation", "post installation"},
+		{"stage in another case",
+			mandateEvent{details_cause: "bank_account_closed"},
+			matchResult{method: 1, account: crmAccount{crm_stage_name: " sold "}},
+			"Pre-Installation", "pre installation"},
+		{"inactive account",
+			mandateEvent{details_cause: "bank_account_closed"},
+			matchResult{method: 1, account: crmAccount{crm_stage_name: "INACTIVE"}},
+			"No action - Inactive", "inactive"},
+		{"unknown stage",
+			mandateEvent{details_cause: "bank_account_closed"},
+			matchResult{method: 1, account: crmAccount{crm_stage_name: "CHURNED"}},
+			"Pre-Installation", "default"},
+		{"no account",
+			mandateEvent{details_cause: "bank_account_closed"},
+			matchResult{},
+			"Pre-Installation", "default"},
+		{"ambiguous before stage",
+			mandateEvent{details_cause: "bank_account_closed"},
+			matchResult{method: 4, ambiguous: true, account: crmAccount{crm_stage_name: "ACTIVE"}},
+			"To check - ambiguous match", "ambiguous match"},
+		{"deleted account",
+			mandateEvent{details_cause: "bank_account_closed"},
+			matchResult{method: 1, deleted_at: "2026-10-14", account: crmAccount{crm_stage_name: "ACTIVE"}},
+			"To check - deleted CRM account", "deleted account"},
+		{"at our request before all",
+			mandateEvent{details_description: "The mandate was cancelled at your request."},
+			matchResult{method: 4, ambiguous: true, account: crmAccount{crm_stage_name: "ACTIVE"}},
+			"No action - at our request", "at our request"},
+	}
+	for _, tt := range tests {
+		team, rule := routing.route(&tt.event, tt.match)
+		if team != tt.team || rule != tt.rule {
+			t.Errorf("%s: route = %q by %q, want %q by %q", tt.name, team, rule, tt.team, tt.rule)
+		}
+	}
+}
+
+func TestRuleApplies(t *testing.T) {
+	yes, no := true, false
+	event := mandateEvent{action: "cancelled", details_cause: "bank_account_closed", details_reason_code: "ar01",
+		mandates_scheme: "sepa_core", details_description: "Account closed by the bank"}
+	match := matchResult{method: 3, account: crmAccount{crm_stage_name: "ACTIVE"}}
+	tests := []struct {
+		name string
+		rule routingRule
+		want bool
+	}{
+		{"no conditions", routingRule{}, true},
+		{"action", routingRule{Action: []string{"failed", "Cancelled"}}, true},
+		{"other action", routingRule{Action: []string{"failed"}}, false},
+		{"reason code", routingRule{DetailsReasonCode: []string{"AR01"}}, true},
+		{"scheme of the mandate", routingRule{Scheme: []string{"SEPA_CORE"}}, true},
+		{"reason category", routingRule{ReasonCategory: []string{"bank account"}}, true},
+		{"match method", routingRule{MatchMethod: []string{"gocardless customer id"}}, true},
+		{"other match method", routingRule{MatchMethod: []string{"fuzzy name"}}, false},
+		{"not ambiguous", routingRule{Ambiguous: &no}, true},
+		{"ambiguous", routingRule{Ambiguous: &yes}, false},
+		{"not deleted", routingRule{CRMDeleted: &yes}, false},
+		{"description", routingRule{DetailsDescription: "(?i)closed"}, true},
+		{"other description", routingRule{DetailsDescription: "request"}, false},
+		{"all conditions hold", routingRule{CRMStageName: []string{"ACTIVE"}, Action: []string{"cancelled"}, DetailsDescription: "bank"}, true},
+		{"one condition fails", routingRule{CRMStageName: []string{"ACTIVE"}, Action: []string{"failed"}}, false},
+	}
+	for _, tt := range tests {
+		routing := routingConfig{Teams: []routingTeam{{Name: "Team"}}, DefaultTeam: "Team", Rules: []routingRule{tt.rule}}
+		routing.Rules[0].Team = "Team"
+		if err := routing.check(); err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		if got := routing.Rules[0].applies(&event, match); got != tt.want {
+			t.Errorf("%s: applies = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRoutingCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		routing routingConfig
+		want    string
+	}{
+		{"unknown default team", routingConfig{Teams: []routingTeam{{Name: "A"}}, DefaultTeam: "B"}, `default_team "B" is not one of the teams`},
+		{"unknown rule team", routingConfig{Teams: []routingTeam{{Name: "A"}}, DefaultTeam: "A", Rules: []routingRule{{Team: "B"}}}, `rule "rule 1": team "B" is not one of the teams`},
+		{"reserved team", routingConfig{Teams: []routingTeam{{Name: autoResolvedTeam}}, DefaultTeam: autoResolvedTeam}, "is reserved"},
+		{"unknown category", routingConfig{Teams: []routingTeam{{Name: "A"}}, DefaultTeam: "A", Rules: []routingRule{{Team: "A", ReasonCategory: []string{"weather"}}}}, `reason_category "weather"`},
+		{"bad pattern", routingConfig{Teams: []routingTeam{{Name: "A"}}, DefaultTeam: "A", Rules: []routingRule{{Team: "A", DetailsDescription: "("}}}, "details_description"},
+	}
+	for _, tt := range tests {
+		err := tt.routing.check()
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: check() = %v, want an error with %q", tt.name, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"time"
+)
+
+// Version of cm recorded with every import, set at build time with -ldflags "-X main.version=..."
+var version = "1"
+
+// The version of cm with the commit it was built from, if the build knows it
+func cmVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" && len(setting.Value) >= 7 {
+				return version + " (" + setting.Value[:7] + ")"
+			}
+		}
+	}
+	return version
+}
+
+// The import of one input file in table importRuns, with the number of its rows per outcome
+// and the file of its rejected rows
+type importRun struct {
+	tx            *importTx
+	run_id        int64
+	source        string
+	file          string
+	imported_for  string
+	started       time.Time
+	read          int
+	inserted      int
+	updated       int
+	skipped       int
+	failed        int
+	rejected      int
+	rejected_file string
+	rejectedRows  *csvFile
+}
+
+// SHA-256 of a file as hex string
+func fileSHA256(fileName string) (string, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Record the start of the import of a file of source (crm, elevate, mandates, payments)
+// for day timestamp in the transaction of the import. A file with the same content as an earlier
+// import of the source is reported.
+func startImportRun(tx *importTx, source string, fileName string, timestamp string) *importRun {
+	checksum, err := fileSHA256(fileName)
+	if err != nil {
+		log.Fatalf("Cannot read file: %s %s", fileName, err)
+	}
+	if path, err := filepath.Abs(fileName); err == nil {
+		fileName = path
+	}
+	r := &importRun{tx: tx, source: source, file: fileName, imported_for: timestamp, started: time.Now()}
+
+	var duplicate_of int64
+	var first_path, first_started_at string
+	err = tx.QueryRow(`SELECT run_id, file_path, started_at FROM importRuns WHERE source = ? AND sha256 = ? ORDER BY run_id LIMIT 1`,
+		source, checksum).Scan(&duplicate_of, &first_path, &first_started_at)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Fatalf("Cannot read importRuns: %s", err)
+	}
+	if duplicate_of != 0 {
+		fmt.Printf("WARNING: %s has the same content as %s, imported on %s (run %d)\n", fileName, first_path, first_started_at, duplicate_of)
+	}
+
+	SQLInsertImportRun := `
+		INSERT INTO importRuns(source, file_path, sha256, imported_for, started_at, cm_version, duplicate_of)
+		values(?, ?, ?, ?, ?, ?, ?)`
+	result, err := tx.Exec(SQLInsertImportRun, source, fileName, checksum, timestamp, r.started.Format("2006-01-02 15:04:05"), cmVersion(), duplicate_of)
+	if err != nil {
+		log.Fatalf("Insert into table importRuns failed for %s: %s", fileName, err)
+	}
+	if r.run_id, err = result.LastInsertId(); err != nil {
+		log.Fatalf("Insert into table importRuns failed for %s: %s", fileName, err)
+	}
+	return r
+}
+
+// Record the row counts and the duration of the import, close the rejected-rows file
+func (r *importRun) finish() {
+	duration := time.Since(r.started)
+	if r.rejectedRows != nil {
+		r.rejectedRows.close()
+	}
+	SQLUpdateImportRun := `
+		UPDATE importRuns
+		SET duration_ms = ?, rows_read = ?, rows_inserted = ?, rows_updated = ?, rows_skipped = ?, rows_failed = ?,
+		    rows_rejected = ?, rejected_file = ?
+		WHERE run_id = ?`
+	_, err := r.tx.Exec(SQLUpdateImportRun, duration.Milliseconds(), r.read, r.inserted, r.updated, r.skipped, r.failed,
+		r.rejected, r.rejected_file, r.run_id)
+	if err != nil {
+		log.Fatalf("Update of table importRuns failed for %s: %s", r.file, err)
+	}
+	fmt.Printf("SUCCESS: Imported %s file in %s: %d rows read, %d inserted, %d updated, %d skipped, %d failed, %d rejected\n",
+		r.source, duration.Round(time.Millisecond), r.read, r.inserted, r.updated, r.skipped, r.failed, r.rejected)
+	if r.rejected > 0 {
+		fmt.Println("WARNING: Rejected rows were written to", r.rejected_file)
+	}
+}
+
+// cm runs list: the history of the imports, latest first
+func runsCommand(args []string, defaultDatabaseName string) {
+	var dbName, source string
+	var limit int
+	flags := flag.NewFlagSet("runs list", flag.ExitOnError)
+	flags.StringVar(&dbName, "db", defaultDatabaseName, "Sqlite database to use")
+	flags.StringVar(&source, "source", "", "Only the imports of crm, elevate, mandates or payments")
+	flags.IntVar(&limit, "limit", 50, "Number of imports to list")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage:")
+		fmt.Fprintln(flags.Output(), "  cm runs list [-db file] [-source name] [-limit n]")
+		flags.PrintDefaults()
+	}
+	if len(args) == 0 || args[0] != "list" {
+		flags.Usage()
+		os.Exit(2)
+	}
+	flags.Parse(args[1:])
+
+	db := openDatabaseReadOnly(dbName)
+	defer db.Close()
+
+	SQLGetImportRuns := `
+		SELECT run_id, source, file_path, sha256, imported_for, started_at, duration_ms,
+		    rows_read, rows_inserted, rows_updated, rows_skipped, rows_failed, rows_rejected, cm_version, duplicate_of
+		FROM importRuns
+		WHERE ? = '' OR source = ?
+		ORDER BY run_id DESC
+		LIMIT ?`
+	row, err := db.Query(SQLGetImportRuns, source, source, limit)
+	if err != nil {
+		log.Fatalf("Cannot read importRuns: %s", err)
+	}
+	defer row.Close()
+
+	fmt.Printf("%5s  %-19s  %-8s  %-10s  %8s  %6s  %6s  %6s  %6s  %6s  %6s  %-12s  %s\n",
+		"run", "started at", "source", "for day", "ms", "read", "insert", "update", "skip", "fail", "reject", "sha256", "file")
+	for row.Next() {
+		var run_id, duration_ms, read, inserted, updated, skipped, failed, rejected, duplicate_of int64
+		var source, file_path, checksum, imported_for, started_at, cm_version string
+		err := row.Scan(&run_id, &source, &file_path, &checksum, &imported_for, &started_at, &duration_ms,
+			&read, &inserted, &updated, &skipped, &failed, &rejected, &cm_version, &duplicate_of)
+		if err != nil {
+			log.Fatalf("Cannot read importRuns: %s", err)
+		}
+		if len(checksum) > 12 {
+			checksum = checksum[:12]
+		}
+		fmt.Printf("%5d  %-19s  %-8s  %-10s  %8d  %6d  %6d  %6d  %6d  %6d  %6d  %-12s  %s  (cm %s)\n",
+			run_id, started_at, source, imported_for, duration_ms, read, inserted, updated, skipped, failed, rejected, checksum, file_path, cm_version)
+		if duplicate_of != 0 {
+			fmt.Printf("%5s  same content as run %d\n", "", duplicate_of)
+		}
+	}
+	if err = row.Err(); err != nil {
+		log.Fatalf("Cannot read importRuns: %s", err)
+	}
+}
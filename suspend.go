@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// import failed payment requests from specific file, stamped as imported on day timestamp (YYYY-MM-DD)
+func importPaymentEvents(db *DB, csvFileName string, timestamp string, format inputFormat, batchSize int) {
+	fileData, err := os.Open(csvFileName)
+	if err != nil {
+		fmt.Printf("Skipping Failed Payments file, as there is no current %s file provided....\n", csvFileName)
+		return
+	}
+	defer fileData.Close()
+
+	// Read the header row
+	recordData := readCSVFile(fileData, csvFileName, format)
+	columns := readHeader("payments", csvFileName, recordData)
+	tx := beginImport(db, csvFileName, batchSize)
+	run := startImportRun(tx, "payments", csvFileName, timestamp)
+
+	SQLInsertPaymentEvents := `
+		INSERT INTO paymentEvents(
+			id, created_at, action, details_cause, details_description, details_reason_code,
+			payments_id, payments_amount, payments_charge_date, mandates_id,
+			customers_id, customers_given_name, customers_family_name, customers_company_name,
+			customers_metadata_leadID, imported_at
+		) values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	commandSQL := tx.prepare("insert into paymentEvents", SQLInsertPaymentEvents)
+
+	for {
+		tx.next()
+		record, err := recordData.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		run.read++
+		if !run.check(columns, recordData, record, err) {
+			continue
+		}
+
+		id := columns.get(record, "id")
+		_, err = commandSQL.Exec(
+			id,
+			columns.get(record, "created_at"),
+			columns.get(record, "action"),
+			columns.get(record, "details_cause"),
+			columns.get(record, "details_description"),
+			columns.get(record, "details_reason_code"),
+			columns.get(record, "payments_id"),
+			columns.get(record, "payments_amount"),
+			columns.get(record, "payments_charge_date"),
+			columns.get(record, "mandates_id"),
+			columns.get(record, "customers_id"),
+			columns.get(record, "customers_given_name"),
+			columns.get(record, "customers_family_name"),
+			columns.get(record, "customers_company_name"),
+			columns.get(record, "customers_metadata_leadID"),
+			timestamp)
+		if err != nil {
+			if strings.Contains(fmt.Sprint(err), "UNIQUE constraint failed: paymentEvents.id") {
+				fmt.Println("SUCCESS: Skipped existing record paymentEvents with id:", id)
+				run.skipped++
+			} else {
+				fmt.Println("ERROR:   Insert into table paymentEvents failed for id =", id, err)
+				run.failed++
+			}
+		} else {
+			fmt.Println("SUCCESS: Insert into table paymentEvents with id:", id)
+			run.inserted++
+		}
+	}
+	run.finish()
+	tx.finish()
+	fmt.Println("***********************************************************")
+	fmt.Println("PROCESSING FAILED PAYMENTS                       --   ended")
+	fmt.Println("***********************************************************")
+	fmt.Println(" ")
+}
+
+// A column of the customers-to-suspend file: its header and its value for a suspended payment
+type suspensionColumn struct {
+	name  string
+	value func(s *suspension, match *matchResult) string
+}
+
+// A payment suspended on a day, with the customer and mandate of its failed payment requests
+type suspension struct {
+	payments_id            string
+	timestamp              string
+	payment_requests_count int
+	event                  mandateEvent
+}
+
+// The columns of the customers-to-suspend file in their order, also for the header row
+var suspensionColumns = []suspensionColumn{
+	{"payments_id", func(s *suspension, match *matchResult) string { return s.payments_id }},
+	{"timestamp", func(s *suspension, match *matchResult) string { return s.timestamp }},
+	{"payment_requests_count", func(s *suspension, match *matchResult) string { return strconv.Itoa(s.payment_requests_count) }},
+	{"customers_id", func(s *suspension, match *matchResult) string { return s.event.customers_id }},
+	{"customers_given_name", func(s *suspension, match *matchResult) string { return s.event.customers_given_name }},
+	{"customers_family_name", func(s *suspension, match *matchResult) string { return s.event.customers_family_name }},
+	{"customers_metadata_leadID", func(s *suspension, match *matchResult) string { return s.event.customers_metadata_leadID }},
+	{"mandates_id", func(s *suspension, match *matchResult) string { return s.event.mandates_id }},
+	{"crm_account_number", func(s *suspension, match *matchResult) string { return match.account.crm_account_number }},
+	{"crm_id", func(s *suspension, match *matchResult) string { return match.account.crm_id }},
+	{"crm_name", func(s *suspension, match *matchResult) string { return match.account.crm_name }},
+	{"crm_email", func(s *suspension, match *matchResult) string { return match.account.crm_email }},
+	{"crm_premise_address", func(s *suspension, match *matchResult) string { return match.account.crm_premise_address }},
+	{"crm_stage_name", func(s *suspension, match *matchResult) string { return match.account.crm_stage_name }},
+	{"match_method", func(s *suspension, match *matchResult) string { return matchMethodName(match.method) }},
+	{"match_note", func(s *suspension, match *matchResult) string { return match.note }},
+	{"crm_deleted_at", func(s *suspension, match *matchResult) string { return match.deleted_at }},
+}
+
+// Record the payments with countSuspend or more failed payment requests up to day timestamp
+// in table paymentsSuspended, and export the ones new or increased that day to csvSuspendTo.
+// The count of a payment already suspended is only updated, if it has increased.
+func processPaymentsSuspended(db *DB, timestamp string, countSuspend int, csvSuspendTo string, fuzzyThreshold float64, options exportOptions) {
+	SQLSuspendPayments := `
+		INSERT INTO paymentsSuspended(
+			payments_id, timestamp, payment_requests_count,
+			customers_id, customers_given_name, customers_family_name, customers_metadata_leadID, mandates_id
+		)
+		SELECT payments_id, ?, count(DISTINCT id),
+			max(customers_id), max(customers_given_name), max(customers_family_name), max(customers_metadata_leadID), max(mandates_id)
+		FROM paymentEvents
+		WHERE payments_id != '' AND imported_at <= ?
+		GROUP BY payments_id
+		HAVING count(DISTINCT id) >= ?
+		ON CONFLICT(payments_id)
+		DO UPDATE SET
+		    timestamp=excluded.timestamp,
+		    payment_requests_count=excluded.payment_requests_count
+		WHERE excluded.payment_requests_count > paymentsSuspended.payment_requests_count
+	`
+	if _, err := db.Exec(SQLSuspendPayments, timestamp, timestamp, countSuspend); err != nil {
+		log.Fatalf("SQL Statement execution failed: suspend payments %s", err)
+	}
+
+	SQLTodaysSuspensions := `
+		SELECT payments_id, timestamp, payment_requests_count,
+			customers_id, customers_given_name, customers_family_name, customers_metadata_leadID, mandates_id
+		FROM paymentsSuspended
+		WHERE timestamp = ?
+		ORDER BY payments_id
+	`
+	row, err := db.Query(SQLTodaysSuspensions, timestamp)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var suspensions []suspension
+	for row.Next() {
+		var s suspension
+		row.Scan(&s.payments_id, &s.timestamp, &s.payment_requests_count, &s.event.customers_id, &s.event.customers_given_name,
+			&s.event.customers_family_name, &s.event.customers_metadata_leadID, &s.event.mandates_id)
+		s.event.customers_name = s.event.customers_given_name + " " + s.event.customers_family_name
+		suspensions = append(suspensions, s)
+	}
+	row.Close()
+
+	// prepare file "customers-to-suspend-YYYY-MM-DD.csv", or .xlsx, without the summary of the teams
+	header := make([]string, len(suspensionColumns))
+	for i, column := range suspensionColumns {
+		header[i] = column.name
+	}
+	options.summary = false
+	targetFile := createExportFile(csvSuspendTo, header, options)
+
+	// find the CRM account of the customer the same way as for mandate events
+	matcher := newMatcher(db, fuzzyThreshold)
+	defer matcher.close()
+	for i := range suspensions {
+		s := &suspensions[i]
+		fmt.Println("Suspend:", s.payments_id, s.event.customers_name, "payment requests:", s.payment_requests_count)
+		match := matcher.matchCRMAccount(&s.event)
+
+		record := make([]string, len(suspensionColumns))
+		for j, column := range suspensionColumns {
+			record[j] = column.value(s, &match)
+		}
+		targetFile.write(record)
+		fmt.Println(" ")
+	}
+	targetFile.close()
+	fmt.Println("***********************************************************")
+	fmt.Println("PROCESSING CUSTOMERS TO SUSPEND                  --   ended")
+	fmt.Println("***********************************************************")
+	fmt.Println(" ")
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Import the failed payment requests of a day, given as payments_id per request
+func importTestPayments(t *testing.T, db *DB, day string, payments ...string) {
+	t.Helper()
+	content := "id,created_at,payments_id,customers_id,customers_given_name,customers_family_name\n"
+	for i, payments_id := range payments {
+		content += fmt.Sprintf("EV%s%d,%sT08:00:00Z,%s,CU%s,Given,Family\n", strings.ReplaceAll(day, "-", ""), i, day, payments_id, payments_id)
+	}
+	fileName := filepath.Join(t.TempDir(), "failed-payments-"+day+".csv")
+	if err := os.WriteFile(fileName, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	importPaymentEvents(db, fileName, day, inputFormat{}, 0)
+}
+
+// The payments_id and payment_requests_count of the rows of a customers-to-suspend file
+func readTestSuspensions(t *testing.T, fileName string) string {
+	t.Helper()
+	file, err := os.Open(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payments []string
+	for _, record := range records[1:] {
+		payments = append(payments, record[0]+"="+record[2])
+	}
+	return strings.Join(payments, " ")
+}
+
+func TestProcessPaymentsSuspended(t *testing.T) {
+	days := []struct {
+		day      string
+		payments []string
+	}{
+		{"2026-10-14", []string{"PM1", "PM1", "PM2", "PM2", "PM2", "PM3"}},
+		{"2026-10-15", []string{"PM1", "PM3"}},
+		{"2026-10-16", []string{"PM1", "PM3"}},
+		{"2026-10-17", []string{"PM4"}},
+	}
+	tests := []struct {
+		countSuspend int
+		want         []string
+	}{
+		// new on a day or increased, a count unchanged since an earlier day is not exported again
+		{3, []string{"PM2=3", "PM1=3", "PM1=4 PM3=3", ""}},
+		{4, []string{"", "", "PM1=4", ""}},
+		{1, []string{"PM1=2 PM2=3 PM3=1", "PM1=3 PM3=2", "PM1=4 PM3=3", "PM4=1"}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("count-suspend %d", tt.countSuspend), func(t *testing.T) {
+			db := newTestDatabase(t)
+			dir := t.TempDir()
+			for i, d := range days {
+				importTestPayments(t, db, d.day, d.payments...)
+				fileName := filepath.Join(dir, "customers-to-suspend-"+d.day+".csv")
+				processPaymentsSuspended(db, d.day, tt.countSuspend, fileName, 0.9, exportOptions{delimiter: ','})
+				if got := readTestSuspensions(t, fileName); got != tt.want[i] {
+					t.Errorf("%s: suspended %q, want %q", d.day, got, tt.want[i])
+				}
+			}
+
+			// the timestamp of a payment is the day its count last increased
+			var timestamp string
+			var count int
+			db.QueryRow(`SELECT timestamp, payment_requests_count FROM paymentsSuspended WHERE payments_id = 'PM1'`).Scan(&timestamp, &count)
+			if timestamp != "2026-10-16" || count != 4 {
+				t.Errorf("PM1 suspended on %q with %d payment requests, want 2026-10-16 with 4", timestamp, count)
+			}
+		})
+	}
+}
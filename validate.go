@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A check of one field of the rows of a CSV source: whether it must have a value,
+// the prefix of its ids and the time layouts its dates may have. Empty values of fields
+// which are not required are not checked.
+type fieldCheck struct {
+	field    string
+	required bool
+	prefix   string
+	layouts  []string
+}
+
+// Checks per CSV source, a row failing one of them is rejected, see README "Rejected rows"
+var rowChecks = map[string][]fieldCheck{
+	"elevate": {
+		{field: "elevate_account_number", required: true},
+	},
+	"crm": {
+		{field: "crm_id", required: true},
+	},
+	"mandates": {
+		{field: "id", required: true, prefix: "EV"},
+		{field: "created_at", required: true, layouts: []string{time.RFC3339}},
+		{field: "mandates_id", required: true, prefix: "MD"},
+		{field: "customers_id", required: true, prefix: "CU"},
+		{field: "mandates_created_at", layouts: []string{time.RFC3339, "2006-01-02"}},
+	},
+	"payments": {
+		{field: "id", required: true, prefix: "EV"},
+		{field: "created_at", required: true, layouts: []string{time.RFC3339}},
+		{field: "payments_id", required: true, prefix: "PM"},
+		{field: "mandates_id", prefix: "MD"},
+		{field: "customers_id", prefix: "CU"},
+		{field: "payments_charge_date", layouts: []string{"2006-01-02"}},
+	},
+}
+
+// Check a row against the header and the checks of its source, return why it is rejected
+func (c columnIndex) validate(record []string) error {
+	if len(record) != len(c.header) {
+		return fmt.Errorf("%d fields instead of %d", len(record), len(c.header))
+	}
+	for _, check := range rowChecks[c.source] {
+		value := c.get(record, check.field)
+		switch {
+		case value == "" && check.required:
+			return fmt.Errorf("%s is empty", check.field)
+		case value == "":
+		case check.prefix != "" && !strings.HasPrefix(value, check.prefix):
+			return fmt.Errorf("%s %q does not start with %s", check.field, value, check.prefix)
+		case len(check.layouts) > 0 && !isDate(value, check.layouts):
+			return fmt.Errorf("%s %q is not a date like %s", check.field, value, strings.Join(check.layouts, " or "))
+		}
+	}
+	return nil
+}
+
+// Whether a value is a date in one of the layouts
+func isDate(value string, layouts []string) bool {
+	for _, layout := range layouts {
+		if _, err := time.Parse(layout, value); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Name of the rejected-rows file of an input file: <input>-rejected-YYYY-MM-DD.csv
+func rejectedFileName(fileName string, timestamp string) string {
+	return strings.TrimSuffix(fileName, filepath.Ext(fileName)) + "-rejected-" + timestamp + ".csv"
+}
+
+// Check a row read from the file: a row which could not be read (err) or fails the checks
+// of its source is written with its line number and the reason to the rejected-rows file,
+// which is created with the first rejected row. Returns whether the row can be imported.
+func (r *importRun) check(columns columnIndex, recordData *csv.Reader, record []string, err error) bool {
+	line := 0
+	var parseError *csv.ParseError
+	if errors.As(err, &parseError) {
+		line = parseError.StartLine
+	} else if len(record) > 0 {
+		line, _ = recordData.FieldPos(0)
+	}
+	if err == nil {
+		err = columns.validate(record)
+	}
+	if err == nil {
+		return true
+	}
+
+	fmt.Println("ERROR:   Rejected line", line, "of", r.file, err)
+	if r.rejectedRows == nil {
+		r.rejected_file = rejectedFileName(r.file, r.imported_for)
+		r.rejectedRows = createCSVFile(r.rejected_file, append([]string{"line", "reason"}, columns.header...), exportOptions{delimiter: ','})
+	}
+	r.rejectedRows.write(append([]string{strconv.Itoa(line), err.Error()}, record...))
+	r.rejected++
+	return false
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The columns of a mandates file with the given header, which need not have all required columns
+func testMandateColumns(fileName string, header ...string) columnIndex {
+	columns := columnIndex{source: "mandates", fileName: fileName, header: header, index: make(map[string]int)}
+	for i, field := range header {
+		columns.index[field] = i
+	}
+	return columns
+}
+
+func TestValidate(t *testing.T) {
+	columns := testMandateColumns("mandates.csv", "id", "created_at", "mandates_id", "customers_id", "mandates_created_at")
+	tests := []struct {
+		name   string
+		record []string
+		want   string
+	}{
+		{"valid", []string{"EV1", "2026-10-15T08:00:00Z", "MD1", "CU1", "2026-01-01"}, ""},
+		{"no mandate creation", []string{"EV1", "2026-10-15T08:00:00Z", "MD1", "CU1", ""}, ""},
+		{"empty id", []string{"", "2026-10-15T08:00:00Z", "MD1", "CU1", ""}, "id is empty"},
+		{"bad created_at", []string{"EV1", "15.10.2026 08:00", "MD1", "CU1", ""}, `created_at "15.10.2026 08:00" is not a date like ` + time.RFC3339},
+		{"day as created_at", []string{"EV1", "2026-10-15", "MD1", "CU1", ""}, `created_at "2026-10-15" is not a date like ` + time.RFC3339},
+		{"wrong prefix", []string{"EV1", "2026-10-15T08:00:00Z", "MD1", "XX1", ""}, `customers_id "XX1" does not start with CU`},
+		{"bad mandate creation", []string{"EV1", "2026-10-15T08:00:00Z", "MD1", "CU1", "2026-13-01"}, `mandates_created_at "2026-13-01" is not a date like ` + time.RFC3339 + " or 2006-01-02"},
+		{"too few fields", []string{"EV1", "2026-10-15T08:00:00Z", "MD1", "CU1"}, "4 fields instead of 5"},
+		{"too many fields", []string{"EV1", "2026-10-15T08:00:00Z", "MD1", "CU1", "", ""}, "6 fields instead of 5"},
+	}
+	for _, tt := range tests {
+		err := columns.validate(tt.record)
+		got := ""
+		if err != nil {
+			got = err.Error()
+		}
+		if got != tt.want {
+			t.Errorf("%s: validate(%q) = %q, want %q", tt.name, tt.record, got, tt.want)
+		}
+	}
+}
+
+func TestIsDate(t *testing.T) {
+	tests := []struct {
+		value   string
+		layouts []string
+		want    bool
+	}{
+		{"2026-10-15T08:00:00Z", []string{time.RFC3339}, true},
+		{"2026-10-15T08:00:00.123+02:00", []string{time.RFC3339}, true},
+		{"2026-10-15", []string{time.RFC3339}, false},
+		{"2026-10-15", []string{time.RFC3339, "2006-01-02"}, true},
+		{"2026-02-30", []string{"2006-01-02"}, false},
+		{"15.10.2026", []string{"2006-01-02"}, false},
+		{"", []string{"2006-01-02"}, false},
+	}
+	for _, tt := range tests {
+		if got := isDate(tt.value, tt.layouts); got != tt.want {
+			t.Errorf("isDate(%q, %q) = %v, want %v", tt.value, tt.layouts, got, tt.want)
+		}
+	}
+}
+
+func TestCheckRejectsRows(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "mandates.csv")
+	content := "id,created_at,mandates_id,customers_id\n" +
+		"EV1,2026-10-15T08:00:00Z,MD1,CU1\n" +
+		"EV2,2026-10-15T08:00:00Z,MD\"2,CU2\n" +
+		",2026-10-15T08:00:00Z,MD3,CU3\n" +
+		"EV4,2026-10-15T08:00:00Z,MD4\n" +
+		"EV5,2026-10-15T08:00:00Z,MD5,CU5\n"
+	recordData := csv.NewReader(strings.NewReader(content))
+	recordData.FieldsPerRecord = -1
+	header, _ := recordData.Read()
+	columns := testMandateColumns(fileName, header...)
+	run := &importRun{file: fileName, imported_for: "2026-10-15"}
+
+	var imported []string
+	for {
+		record, err := recordData.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if run.check(columns, recordData, record, err) {
+			imported = append(imported, columns.get(record, "id"))
+		}
+	}
+	if run.rejectedRows != nil {
+		run.rejectedRows.close()
+	}
+
+	if strings.Join(imported, " ") != "EV1 EV5" {
+		t.Errorf("imported %q, want EV1 EV5", imported)
+	}
+	if run.rejected != 3 {
+		t.Errorf("%d rows rejected, want 3", run.rejected)
+	}
+	data, err := os.ReadFile(rejectedFileName(fileName, "2026-10-15"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rejectedData := csv.NewReader(strings.NewReader(string(data)))
+	rejectedData.FieldsPerRecord = -1
+	rejected, err := rejectedData.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantLines := []string{"line", "3", "4", "5"}
+	if len(rejected) != len(wantLines) {
+		t.Fatalf("rejected file has %d rows, want %d: %q", len(rejected), len(wantLines), rejected)
+	}
+	for i, want := range wantLines {
+		if rejected[i][0] != want {
+			t.Errorf("row %d of the rejected file is line %q, want %q: %q", i, rejected[i][0], want, rejected[i])
+		}
+	}
+	if !strings.Contains(rejected[1][1], "bare \" in non-quoted-field") {
+		t.Errorf("reason of the bare quote = %q", rejected[1][1])
+	}
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// How the values of a column are written to XLSX files: "date" for dates and timestamps,
+// "number", or "link" for URLs. All other columns are text.
+var xlsxColumnTypes = map[string]string{
+	"created_at":                         "date",
+	"mandates_created_at":                "date",
+	"mandates_next_possible_charge_date": "date",
+	"imported_at":                        "date",
+	"case_opened_at":                     "date",
+	"timestamp":                          "date",
+	"match_score":                        "number",
+	"case_id":                            "number",
+	"event_count":                        "number",
+	"case_age_days":                      "number",
+	"payment_requests_count":             "number",
+	"customers_metadata_link":            "link",
+}
+
+// Cell styles of styles.xml
+const (
+	xlsxStyleHeader   = 1
+	xlsxStyleDate     = 2
+	xlsxStyleDateTime = 3
+	xlsxStyleLink     = 4
+)
+
+// An export file written as XLSX workbook: the rows are kept until close writes the workbook
+// with a frozen header row, an auto-filter, column widths and an optional summary sheet
+type xlsxFile struct {
+	fileName string
+	header   []string
+	rows     [][]string
+	summary  bool
+}
+
+// Create an XLSX export file with its header row
+func createXLSXFile(fileName string, header []string, summary bool) *xlsxFile {
+	return &xlsxFile{fileName: fileName, header: header, summary: summary}
+}
+
+// Add one row to an XLSX export file
+func (f *xlsxFile) write(record []string) {
+	f.rows = append(f.rows, record)
+}
+
+// Write the workbook of an XLSX export file
+func (f *xlsxFile) close() {
+	var buffer bytes.Buffer
+	archive := zip.NewWriter(&buffer)
+
+	sheets := []string{"Cases"}
+	if f.summary {
+		sheets = append(sheets, "Summary")
+	}
+	sheetXML, sheetRels := f.casesSheet()
+	parts := []struct{ name, content string }{
+		{"[Content_Types].xml", xlsxContentTypes(len(sheets))},
+		{"_rels/.rels", xlsxRootRels},
+		{"xl/workbook.xml", xlsxWorkbook(sheets, len(f.header), len(f.rows)+1)},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels(len(sheets))},
+		{"xl/styles.xml", xlsxStyles},
+		{"xl/worksheets/sheet1.xml", sheetXML},
+		{"xl/worksheets/_rels/sheet1.xml.rels", sheetRels},
+	}
+	if f.summary {
+		parts = append(parts, struct{ name, content string }{"xl/worksheets/sheet2.xml", f.summarySheet()})
+	}
+	modified := time.Now()
+	for _, part := range parts {
+		w, err := archive.CreateHeader(&zip.FileHeader{Name: part.name, Method: zip.Deflate, Modified: modified})
+		if err == nil {
+			_, err = w.Write([]byte(part.content))
+		}
+		if err != nil {
+			log.Fatalf("Cannot write export file: %s %s", f.fileName, err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		log.Fatalf("Cannot write export file: %s %s", f.fileName, err)
+	}
+	if err := os.WriteFile(f.fileName, buffer.Bytes(), 0644); err != nil {
+		log.Fatalf("Cannot write export file: %s %s", f.fileName, err)
+	}
+}
+
+// Column letters of a column index: 0 is A, 26 is AA
+func xlsxColumnName(index int) string {
+	name := ""
+	for index++; index > 0; index = (index - 1) / 26 {
+		name = string(rune('A'+(index-1)%26)) + name
+	}
+	return name
+}
+
+// A text escaped for XML
+func xlsxEscape(text string) string {
+	var buffer bytes.Buffer
+	xml.EscapeText(&buffer, []byte(text))
+	return buffer.String()
+}
+
+// Days since 1899-12-30 of a date or timestamp, as Excel stores dates, and whether it has a time
+func xlsxDate(value string) (float64, bool, bool) {
+	epoch := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t.Sub(epoch).Hours() / 24, false, true
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			t = t.UTC()
+			return t.Sub(epoch).Seconds() / 86400, true, true
+		}
+	}
+	return 0, false, false
+}
+
+// One cell in the type of its column. Values which don't fit the type are written as text.
+func xlsxCell(ref string, value string, columnType string) string {
+	if value == "" {
+		return ""
+	}
+	switch columnType {
+	case "date":
+		if serial, withTime, ok := xlsxDate(value); ok {
+			style := xlsxStyleDate
+			if withTime {
+				style = xlsxStyleDateTime
+			}
+			return fmt.Sprintf(`<c r="%s" s="%d"><v>%s</v></c>`, ref, style, strconv.FormatFloat(serial, 'f', -1, 64))
+		}
+	case "number":
+		if _, err := strconv.ParseFloat(value, 64); err == nil {
+			return fmt.Sprintf(`<c r="%s"><v>%s</v></c>`, ref, value)
+		}
+	case "link":
+		if strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://") {
+			return fmt.Sprintf(`<c r="%s" s="%d" t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, ref, xlsxStyleLink, xlsxEscape(value))
+		}
+	case "header":
+		return fmt.Sprintf(`<c r="%s" s="%d" t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, ref, xlsxStyleHeader, xlsxEscape(value))
+	}
+	return fmt.Sprintf(`<c r="%s" t="inlineStr"><is><t xml:space="preserve">%s</t></is></c>`, ref, xlsxEscape(value))
+}
+
+// The worksheet of the cases and its relationships with the hyperlinks
+func (f *xlsxFile) casesSheet() (string, string) {
+	lastRef := xlsxColumnName(len(f.header)-1) + strconv.Itoa(len(f.rows)+1)
+
+	// width of a column from its longest value, within limits
+	widths := make([]int, len(f.header))
+	for i, name := range f.header {
+		widths[i] = utf8.RuneCountInString(name)
+	}
+	for _, record := range f.rows {
+		for i, value := range record {
+			if n := utf8.RuneCountInString(value); i < len(widths) && n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+
+	var sheet, links, rels strings.Builder
+	sheet.WriteString(xml.Header)
+	sheet.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">`)
+	fmt.Fprintf(&sheet, `<dimension ref="A1:%s"/>`, lastRef)
+	sheet.WriteString(`<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/><selection pane="bottomLeft"/></sheetView></sheetViews>`)
+	sheet.WriteString(`<cols>`)
+	for i, width := range widths {
+		if width < 8 {
+			width = 8
+		}
+		if width > 60 {
+			width = 60
+		}
+		fmt.Fprintf(&sheet, `<col min="%d" max="%d" width="%d" customWidth="1"/>`, i+1, i+1, width+2)
+	}
+	sheet.WriteString(`</cols><sheetData>`)
+
+	sheet.WriteString(`<row r="1">`)
+	for i, name := range f.header {
+		sheet.WriteString(xlsxCell(xlsxColumnName(i)+"1", name, "header"))
+	}
+	sheet.WriteString(`</row>`)
+	linkCount := 0
+	for r, record := range f.rows {
+		rowNumber := strconv.Itoa(r + 2)
+		fmt.Fprintf(&sheet, `<row r="%s">`, rowNumber)
+		for i, value := range record {
+			ref := xlsxColumnName(i) + rowNumber
+			columnType := ""
+			if i < len(f.header) {
+				columnType = xlsxColumnTypes[f.header[i]]
+			}
+			sheet.WriteString(xlsxCell(ref, value, columnType))
+			if columnType == "link" && (strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://")) {
+				linkCount++
+				fmt.Fprintf(&links, `<hyperlink ref="%s" r:id="rId%d"/>`, ref, linkCount)
+				fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/hyperlink" Target="%s" TargetMode="External"/>`, linkCount, xlsxEscape(value))
+			}
+		}
+		sheet.WriteString(`</row>`)
+	}
+	sheet.WriteString(`</sheetData>`)
+	fmt.Fprintf(&sheet, `<autoFilter ref="A1:%s"/>`, lastRef)
+	if linkCount > 0 {
+		sheet.WriteString(`<hyperlinks>` + links.String() + `</hyperlinks>`)
+	}
+	sheet.WriteString(`</worksheet>`)
+
+	sheetRels := xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` + rels.String() + `</Relationships>`
+	return sheet.String(), sheetRels
+}
+
+// The summary worksheet: the number of cases per team and per reason category
+func (f *xlsxFile) summarySheet() string {
+	var sheet strings.Builder
+	sheet.WriteString(xml.Header)
+	sheet.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+	sheet.WriteString(`<cols><col min="1" max="1" width="40" customWidth="1"/><col min="2" max="2" width="10" customWidth="1"/></cols><sheetData>`)
+
+	rowNumber := 0
+	row := func(label string, value string, style string) {
+		rowNumber++
+		n := strconv.Itoa(rowNumber)
+		fmt.Fprintf(&sheet, `<row r="%s">%s%s</row>`, n, xlsxCell("A"+n, label, style), xlsxCell("B"+n, value, style))
+	}
+	for _, column := range []string{"target_team", "reason_category"} {
+		index := -1
+		for i, name := range f.header {
+			if name == column {
+				index = i
+			}
+		}
+		if index < 0 {
+			continue
+		}
+		counts := make(map[string]int)
+		for _, record := range f.rows {
+			counts[record[index]]++
+		}
+		var keys []string
+		for key := range counts {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		if rowNumber > 0 {
+			rowNumber++
+		}
+		row(column, "cases", "header")
+		for _, key := range keys {
+			row(key, strconv.Itoa(counts[key]), "number")
+		}
+		row("total", strconv.Itoa(len(f.rows)), "number")
+	}
+	sheet.WriteString(`</sheetData></worksheet>`)
+	return sheet.String()
+}
+
+// [Content_Types].xml of a workbook with a number of sheets
+func xlsxContentTypes(sheets int) string {
+	var types strings.Builder
+	types.WriteString(xml.Header)
+	types.WriteString(`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">`)
+	types.WriteString(`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>`)
+	types.WriteString(`<Default Extension="xml" ContentType="application/xml"/>`)
+	types.WriteString(`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>`)
+	types.WriteString(`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>`)
+	for i := 1; i <= sheets; i++ {
+		fmt.Fprintf(&types, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, i)
+	}
+	types.WriteString(`</Types>`)
+	return types.String()
+}
+
+// _rels/.rels of a workbook
+const xlsxRootRels = xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+	`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+	`</Relationships>`
+
+// xl/workbook.xml with the sheets and the range of the auto-filter of the first sheet
+func xlsxWorkbook(sheets []string, columns int, rows int) string {
+	var workbook strings.Builder
+	workbook.WriteString(xml.Header)
+	workbook.WriteString(`<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`)
+	for i, name := range sheets {
+		fmt.Fprintf(&workbook, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, xlsxEscape(name), i+1, i+1)
+	}
+	workbook.WriteString(`</sheets><definedNames>`)
+	fmt.Fprintf(&workbook, `<definedName name="_xlnm._FilterDatabase" localSheetId="0" hidden="1">'%s'!$A$1:$%s$%d</definedName>`,
+		sheets[0], xlsxColumnName(columns-1), rows)
+	workbook.WriteString(`</definedNames></workbook>`)
+	return workbook.String()
+}
+
+// xl/_rels/workbook.xml.rels with the sheets and the styles
+func xlsxWorkbookRels(sheets int) string {
+	var rels strings.Builder
+	rels.WriteString(xml.Header)
+	rels.WriteString(`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+	for i := 1; i <= sheets; i++ {
+		fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, i, i)
+	}
+	fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>`, sheets+1)
+	rels.WriteString(`</Relationships>`)
+	return rels.String()
+}
+
+// xl/styles.xml: default, bold header, date (in the locale of Excel), date and time, hyperlink
+const xlsxStyles = xml.Header + `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+	`<numFmts count="1"><numFmt numFmtId="164" formatCode="yyyy-mm-dd hh:mm:ss"/></numFmts>` +
+	`<fonts count="3">` +
+	`<font><sz val="11"/><name val="Calibri"/></font>` +
+	`<font><b/><sz val="11"/><name val="Calibri"/></font>` +
+	`<font><u/><sz val="11"/><color rgb="FF0563C1"/><name val="Calibri"/></font>` +
+	`</fonts>` +
+	`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+	`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+	`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+	`<cellXfs count="5">` +
+	`<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+	`<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/>` +
+	`<xf numFmtId="14" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+	`<xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+	`<xf numFmtId="0" fontId="2" fillId="0" borderId="0" xfId="0" applyFont="1"/>` +
+	`</cellXfs>` +
+	`<cellStyles count="1"><cellStyle name="Normal" xfId="0" builtinId="0"/></cellStyles>` +
+	`</styleSheet>`
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"io"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestXLSXColumnName(t *testing.T) {
+	tests := []struct {
+		index int
+		want  string
+	}{
+		{0, "A"}, {1, "B"}, {25, "Z"}, {26, "AA"}, {27, "AB"}, {51, "AZ"}, {52, "BA"}, {701, "ZZ"}, {702, "AAA"},
+	}
+	for _, tt := range tests {
+		if got := xlsxColumnName(tt.index); got != tt.want {
+			t.Errorf("xlsxColumnName(%d) = %q, want %q", tt.index, got, tt.want)
+		}
+	}
+}
+
+func TestXLSXDate(t *testing.T) {
+	tests := []struct {
+		value    string
+		serial   float64
+		withTime bool
+		ok       bool
+	}{
+		{"2026-10-16", 46311, false, true},
+		{"1900-03-01", 61, false, true},
+		{"2026-10-16T06:00:00Z", 46311.25, true, true},
+		{"2026-10-16T12:00:00+02:00", 46311 + 10.0/24, true, true},
+		{"2026-10-16T00:30:00.500+01:00", 46310 + 23.5/24 + 0.5/86400, true, true},
+		{"2026-10-16 18:00:00", 46311.75, true, true},
+		{"16.10.2026", 0, false, false},
+		{"2026-02-30", 0, false, false},
+		{"", 0, false, false},
+	}
+	for _, tt := range tests {
+		serial, withTime, ok := xlsxDate(tt.value)
+		if math.Abs(serial-tt.serial) > 1e-9 || withTime != tt.withTime || ok != tt.ok {
+			t.Errorf("xlsxDate(%q) = %v, %v, %v, want %v, %v, %v", tt.value, serial, withTime, ok, tt.serial, tt.withTime, tt.ok)
+		}
+	}
+}
+
+func TestXLSXCell(t *testing.T) {
+	tests := []struct {
+		value, columnType, want string
+	}{
+		{"", "", ""},
+		{"Müller & Söhne <GmbH>", "", `<c r="B2" t="inlineStr"><is><t xml:space="preserve">Müller &amp; Söhne &lt;GmbH&gt;</t></is></c>`},
+		{`"quoted" 'text'`, "", `<c r="B2" t="inlineStr"><is><t xml:space="preserve">&#34;quoted&#34; &#39;text&#39;</t></is></c>`},
+		{" leading space", "", `<c r="B2" t="inlineStr"><is><t xml:space="preserve"> leading space</t></is></c>`},
+		{"case_id", "header", `<c r="B2" s="1" t="inlineStr"><is><t xml:space="preserve">case_id</t></is></c>`},
+		{"42", "number", `<c r="B2"><v>42</v></c>`},
+		{"0.93", "number", `<c r="B2"><v>0.93</v></c>`},
+		{"n/a", "number", `<c r="B2" t="inlineStr"><is><t xml:space="preserve">n/a</t></is></c>`},
+		{"2026-10-16", "date", `<c r="B2" s="2"><v>46311</v></c>`},
+		{"2026-10-16T06:00:00Z", "date", `<c r="B2" s="3"><v>46311.25</v></c>`},
+		{"soon", "date", `<c r="B2" t="inlineStr"><is><t xml:space="preserve">soon</t></is></c>`},
+		{"https://example.com/?a=1&b=2", "link", `<c r="B2" s="4" t="inlineStr"><is><t xml:space="preserve">https://example.com/?a=1&amp;b=2</t></is></c>`},
+		{"example.com", "link", `<c r="B2" t="inlineStr"><is><t xml:space="preserve">example.com</t></is></c>`},
+	}
+	for _, tt := range tests {
+		if got := xlsxCell("B2", tt.value, tt.columnType); got != tt.want {
+			t.Errorf("xlsxCell(%q, %q) = %s, want %s", tt.value, tt.columnType, got, tt.want)
+		}
+	}
+}
+
+// The attributes of the elements with a local name in an XML part
+func xmlAttributes(t *testing.T, content string, element string) []map[string]string {
+	t.Helper()
+	var found []map[string]string
+	decoder := xml.NewDecoder(strings.NewReader(content))
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			return found
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if start, ok := token.(xml.StartElement); ok && start.Name.Local == element {
+			attributes := make(map[string]string)
+			for _, a := range start.Attr {
+				attributes[a.Name.Local] = a.Value
+			}
+			found = append(found, attributes)
+		}
+	}
+}
+
+func TestXLSXFile(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "team.xlsx")
+	header := []string{"case_id", "created_at", "customers_metadata_link", "customers_name", "target_team", "reason_category"}
+	f := createXLSXFile(fileName, header, true)
+	f.write([]string{"1", "2026-10-16T06:00:00Z", "https://crm.example.com/?id=1&x=<y>", "Müller & Söhne", "Post-Installation", "bank account"})
+	f.write([]string{"2", "2026-10-15", "", "O'Neill", "Pre-Installation", "bank account"})
+	f.write([]string{"3", "bad date", "https://crm.example.com/?id=3", "Smith", "Post-Installation", "unknown"})
+	f.close()
+
+	archive, err := zip.OpenReader(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+	parts := make(map[string]string)
+	for _, file := range archive.File {
+		r, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts[file.Name] = string(content)
+		xmlAttributes(t, string(content), "") // fails on a part which is not well-formed XML
+	}
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels",
+		"xl/styles.xml", "xl/worksheets/sheet1.xml", "xl/worksheets/_rels/sheet1.xml.rels", "xl/worksheets/sheet2.xml"} {
+		if _, ok := parts[name]; !ok {
+			t.Errorf("workbook has no part %s", name)
+		}
+	}
+
+	// every hyperlink of the sheet has a relationship to the URL of its cell
+	targets := make(map[string]string)
+	for _, rel := range xmlAttributes(t, parts["xl/worksheets/_rels/sheet1.xml.rels"], "Relationship") {
+		targets[rel["Id"]] = rel["Target"]
+	}
+	links := xmlAttributes(t, parts["xl/worksheets/sheet1.xml"], "hyperlink")
+	want := map[string]string{"C2": "https://crm.example.com/?id=1&x=<y>", "C4": "https://crm.example.com/?id=3"}
+	if len(links) != len(want) || len(targets) != len(want) {
+		t.Fatalf("%d hyperlinks and %d relationships, want %d", len(links), len(targets), len(want))
+	}
+	for _, link := range links {
+		if target := targets[link["id"]]; target != want[link["ref"]] {
+			t.Errorf("hyperlink %s %s goes to %q, want %q", link["ref"], link["id"], target, want[link["ref"]])
+		}
+	}
+
+	// every sheet of the workbook has a relationship and a content type
+	sheets := make(map[string]string)
+	for _, rel := range xmlAttributes(t, parts["xl/_rels/workbook.xml.rels"], "Relationship") {
+		sheets[rel["Id"]] = rel["Target"]
+	}
+	for _, sheet := range xmlAttributes(t, parts["xl/workbook.xml"], "sheet") {
+		target := sheets[sheet["id"]]
+		if _, ok := parts["xl/"+target]; !ok || !strings.Contains(parts["[Content_Types].xml"], `"/xl/`+target+`"`) {
+			t.Errorf("sheet %s has the relationship %s to %q, which is not a part with a content type", sheet["name"], sheet["id"], target)
+		}
+	}
+}